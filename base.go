@@ -1,6 +1,8 @@
 package main
 
 import (
+	"bufio"
+	"cmp"
 	"encoding/json"
 	"fmt"
 	"io/fs"
@@ -9,6 +11,9 @@ import (
 	"maps"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -18,6 +23,38 @@ type Base struct{}
 // Namespacing
 var base Base
 
+// Checkout modes
+const (
+	CHECKOUT_SAFE = iota
+	CHECKOUT_FORCE
+	CHECKOUT_MERGE
+)
+
+// Reset modes
+const (
+	RESET_SOFT = iota
+	RESET_MIXED
+	RESET_HARD
+)
+
+// Log merge filters
+const (
+	MERGES_ANY = iota
+	MERGES_ONLY
+	MERGES_EXCLUDED
+)
+
+// History walk orders
+const (
+	ORDER_DEFAULT     = iota // Newest commit first
+	ORDER_DATE               // Newest commit first, but never a parent before all of its children
+	ORDER_AUTHOR_DATE        // As ORDER_DATE using author dates
+	ORDER_TOPO               // Parents after all of their children, without interleaving lines of history
+)
+
+// Length oids are shortened to in formatted log output
+const ABBREV_LENGTH = 7
+
 func (Base) isBranch(name string) bool {
 	ref, err := data.GetRef(filepath.Join("refs/heads", name), true)
 	return err == nil && ref.Value != ""
@@ -25,12 +62,11 @@ func (Base) isBranch(name string) bool {
 
 // Returns true if oid1 is an ancestor of oid2
 func (Base) isAncestorOf(oid1, oid2 string) bool {
-	for oid := range base.iterCommitsAndParents([]string{oid2}) {
-		if oid == oid1 && oid != oid2 {
-			return true
-		}
+	if oid1 == oid2 {
+		return false
 	}
-	return false
+	ok, err := commitGraph.IsAncestor(oid1, oid2)
+	return err == nil && ok
 }
 
 func (Base) iterBranches() (iter.Seq2[string, *RefValue], error) {
@@ -49,9 +85,22 @@ func (Base) iterBranches() (iter.Seq2[string, *RefValue], error) {
 }
 
 func (Base) iterCommitsAndParents(oids []string) iter.Seq[string] {
+	return base.iterCommitsExcluding(oids, nil, false)
+}
+
+// iterCommitsExcluding iterates over the commits reachable from oids that are not reachable from any of excluded.
+// With firstParent, only the first parent of merge commits is followed
+func (Base) iterCommitsExcluding(oids, excluded []string, firstParent bool) iter.Seq[string] {
 	return func(yield func(string) bool) {
 		visited := ds.NewSet([]string{})
 
+		// Marking excluded history as visited stops the walk where it joins it
+		if len(excluded) > 0 {
+			for oid := range base.iterCommitsAndParents(excluded) {
+				visited.Add(oid)
+			}
+		}
+
 		var oid string
 		for len(oids) > 0 {
 			// Pop head off slice
@@ -69,14 +118,164 @@ func (Base) iterCommitsAndParents(oids []string) iter.Seq[string] {
 				panic(err)
 			}
 
-			if len(c.ParentOids) > 0 {
-				// Return first parent next
-				oids = append(c.ParentOids[:1], oids...)
-				// Return other parents later
-				oids = append(oids, c.ParentOids[1:]...)
+			if len(c.ParentOids) > 0 && firstParent {
+				oids = slices.Concat(c.ParentOids[:1], oids)
+			} else if len(c.ParentOids) > 0 {
+				// Return first parent next and other parents later
+				oids = slices.Concat(c.ParentOids[:1], oids, c.ParentOids[1:])
+			}
+		}
+	}
+}
+
+// walkCommits iterates over the commits reachable from oids but not from excluded in the given order.
+// Only ORDER_DEFAULT streams the walk, the other orders need the whole history before yielding
+func (Base) walkCommits(oids, excluded []string, firstParent bool, order int) iter.Seq[string] {
+	byDate := base.iterCommitsByDate(oids, excluded, firstParent)
+	if order == ORDER_DEFAULT {
+		return func(yield func(string) bool) {
+			for oid := range byDate {
+				if !yield(oid) {
+					return
+				}
+			}
+		}
+	}
+
+	return func(yield func(string) bool) {
+		var walked []string
+		commits := make(map[string]*CommitObject)
+		for oid, c := range byDate {
+			walked = append(walked, oid)
+			commits[oid] = c
+		}
+		for _, oid := range base.sortCommits(walked, commits, firstParent, order) {
+			if !yield(oid) {
+				return
+			}
+		}
+	}
+}
+
+// iterCommitsByDate walks history newest commit first using a priority queue, so that commits on
+// different branches are interleaved by date instead of following one branch to its root first
+func (Base) iterCommitsByDate(oids, excluded []string, firstParent bool) iter.Seq2[string, *CommitObject] {
+	type queued struct {
+		oid    string
+		commit *CommitObject
+		seq    int
+	}
+
+	return func(yield func(string, *CommitObject) bool) {
+		visited := ds.NewSet([]string{})
+		if len(excluded) > 0 {
+			for oid := range base.iterCommitsAndParents(excluded) {
+				visited.Add(oid)
+			}
+		}
+
+		// Commits with the same date come out in the order they were found
+		queue := ds.NewHeap(func(a, b queued) bool {
+			if !a.commit.Timestamp.Equal(b.commit.Timestamp) {
+				return a.commit.Timestamp.After(b.commit.Timestamp)
+			}
+			return a.seq < b.seq
+		})
+		push := func(oids ...string) {
+			for _, oid := range oids {
+				if oid == "" || visited.Includes(oid) {
+					continue
+				}
+				visited.Add(oid)
+				c, err := base.GetCommit(oid)
+				if err != nil {
+					panic(err)
+				}
+				queue.Push(queued{oid, c, visited.Length()})
+			}
+		}
+
+		push(oids...)
+		for queue.Length() > 0 {
+			next := queue.Pop()
+			if !yield(next.oid, next.commit) {
+				return
+			}
+			if firstParent && len(next.commit.ParentOids) > 0 {
+				push(next.commit.ParentOids[0])
+			} else {
+				push(next.commit.ParentOids...)
+			}
+		}
+	}
+}
+
+// sortCommits orders oids so that no commit comes before any of its children among commits. The
+// remaining freedom is used to keep dates descending, or for ORDER_TOPO to finish one line of history
+// before starting another
+func (Base) sortCommits(oids []string, commits map[string]*CommitObject, firstParent bool, order int) []string {
+	parentsOf := func(oid string) []string {
+		parents := commits[oid].ParentOids
+		if firstParent {
+			parents = parents[:min(len(parents), 1)]
+		}
+		return slices.DeleteFunc(slices.Clone(parents), func(parent string) bool {
+			return commits[parent] == nil
+		})
+	}
+
+	children := make(map[string]int)
+	for _, oid := range oids {
+		for _, parent := range parentsOf(oid) {
+			children[parent]++
+		}
+	}
+
+	seq := make(map[string]int)
+	ready := ds.NewHeap(func(a, b string) bool {
+		switch order {
+		case ORDER_TOPO:
+			// Acts as a stack, so the parents of the latest commit are continued first
+			return seq[a] > seq[b]
+		case ORDER_AUTHOR_DATE:
+			ta, tb := cmp.Or(commits[a].AuthorTime, commits[a].Timestamp), cmp.Or(commits[b].AuthorTime, commits[b].Timestamp)
+			if !ta.Equal(tb) {
+				return ta.After(tb)
+			}
+		default:
+			if ta, tb := commits[a].Timestamp, commits[b].Timestamp; !ta.Equal(tb) {
+				return ta.After(tb)
+			}
+		}
+		return seq[a] < seq[b]
+	})
+	push := func(oids []string) {
+		if order == ORDER_TOPO {
+			// Pushed in reverse so the first one is popped first
+			oids = slices.Clone(oids)
+			slices.Reverse(oids)
+		}
+		for _, oid := range oids {
+			seq[oid] = len(seq)
+			ready.Push(oid)
+		}
+	}
+
+	push(slices.DeleteFunc(slices.Clone(oids), func(oid string) bool { return children[oid] > 0 }))
+	sorted := make([]string, 0, len(oids))
+	for ready.Length() > 0 {
+		oid := ready.Pop()
+		sorted = append(sorted, oid)
+
+		var freed []string
+		for _, parent := range parentsOf(oid) {
+			if children[parent]--; children[parent] == 0 {
+				freed = append(freed, parent)
 			}
 		}
+		push(freed)
 	}
+	return sorted
 }
 
 func (Base) iterTreeEntries(oid string) (iter.Seq2[int, TreeEntry], error) {
@@ -179,6 +378,11 @@ func (Base) getStructuredIndex() (map[string]interface{}, error) {
 		return nil, err
 	}
 
+	return base.structureIndex(index), nil
+}
+
+// structureIndex takes a flat map of path to oid and nests it to mirror the directory structure
+func (Base) structureIndex(index map[string]string) map[string]interface{} {
 	structuredIndex := make(map[string]interface{})
 	for path, oid := range index {
 		dirs := strings.Split(path, "/")
@@ -198,37 +402,133 @@ func (Base) getStructuredIndex() (map[string]interface{}, error) {
 
 		}
 	}
-	return structuredIndex, nil
+	return structuredIndex
 }
 
-func (Base) checkoutIndex(index map[string]string) error {
-	if err := data.emptyCurrentDir(); err != nil {
+// checkoutIndex updates the working directory from the current index to the target index. Only files whose
+// oid differs between the two are touched. Unless mode is CHECKOUT_FORCE, the update is aborted before
+// anything is written if it would overwrite staged changes, local modifications or untracked files.
+// CHECKOUT_MERGE instead carries local modifications over by merging them into the target content
+func (Base) checkoutIndex(current, target map[string]string, mode int) error {
+	writes := make(map[string][]byte)
+	var toWrite, toDelete []string
+	var conflicts CheckoutConflictError
+
+	// Changes are staged where the index differs from the tree of HEAD
+	head, err := base.getCommitTree(HEAD)
+	if err != nil {
 		return err
 	}
 
-	for path, oid := range index {
-		if err := os.MkdirAll(filepath.Dir(path), FP); err != nil && !os.IsExist(err) {
-			return err
+	for path, oids := range diff.compareTrees(current, target) {
+		currentOid, targetOid := oids[0], oids[1]
+
+		// Identical entries in both indexes keep any local changes
+		if currentOid == targetOid && mode != CHECKOUT_FORCE {
+			continue
 		}
-		if oid == "" {
-			return fmt.Errorf("empty oid: %v", index)
+		// The target replaces the index entry, so staged changes would be lost even if the working file is kept
+		if currentOid != head[path] && mode != CHECKOUT_FORCE {
+			conflicts.staged = append(conflicts.staged, path)
+			continue
 		}
 
-		obj, t, err := data.GetObject(oid)
-		if err != nil {
+		workingOid := ""
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			if workingOid, err = base.hashWorkingFile(path); err != nil {
+				return err
+			}
+		}
+
+		if workingOid == targetOid {
+			continue
+		}
+		if mode == CHECKOUT_FORCE {
+			if targetOid == "" {
+				toDelete = append(toDelete, path)
+			} else {
+				toWrite = append(toWrite, path)
+			}
+			continue
+		}
+
+		switch {
+		case workingOid == "" && currentOid == "":
+			toWrite = append(toWrite, path)
+		case workingOid == "":
+			// Deleted locally, only restore it if the target changes it
+			if targetOid != "" {
+				toWrite = append(toWrite, path)
+			}
+		case currentOid == "":
+			conflicts.untracked = append(conflicts.untracked, path)
+		case workingOid == currentOid:
+			if targetOid == "" {
+				toDelete = append(toDelete, path)
+			} else {
+				toWrite = append(toWrite, path)
+			}
+		case mode == CHECKOUT_MERGE && targetOid != "":
+			out, err := diff.MergeBlobs(path, []string{workingOid, currentOid, targetOid})
+			if err != nil {
+				return err
+			}
+			writes[path] = out
+		case mode == CHECKOUT_MERGE:
+			// Locally modified files deleted in the target are left in place
+		default:
+			conflicts.modified = append(conflicts.modified, path)
+		}
+	}
+
+	if len(conflicts.staged) > 0 || len(conflicts.modified) > 0 || len(conflicts.untracked) > 0 {
+		return conflicts
+	}
+
+	for _, path := range toDelete {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		data.removeEmptyDirs(filepath.Dir(path))
+	}
+
+	for _, path := range toWrite {
+		if err := os.MkdirAll(filepath.Dir(path), FP); err != nil && !os.IsExist(err) {
 			return err
 		}
-		if t != BLOB {
-			return ObjectTypeError{received: t, expected: BLOB}
+		if err := base.writeWorkingFile(path, target[path]); err != nil {
+			return err
 		}
+	}
 
-		if err := os.WriteFile(path, obj, FP); err != nil {
+	for path, content := range writes {
+		if err := os.WriteFile(path, content, FP); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// hashWorkingFile stores the file at path as a blob, or as a pointer blob if it is a large file
+func (Base) hashWorkingFile(path string) (string, error) {
+	if lfs.isTracked(path) {
+		return lfs.hashFile(path)
+	}
+	return data.hashFile(path)
+}
+
+// writeWorkingFile writes the content of a blob to path, materialising large files from their pointer
+func (Base) writeWorkingFile(path, oid string) error {
+	lfsOid, isPointer, err := lfs.readPointer(oid)
+	if err != nil {
+		return err
+	}
+	if isPointer {
+		return lfs.materialise(lfsOid, path)
+	}
+	return data.copyObjectToFile(oid, path)
+}
+
 func (Base) printStructuredIndex(m map[string]interface{}, level int) {
 	for k, v := range m {
 		if vMap, ok := v.(map[string]interface{}); ok {
@@ -240,6 +540,12 @@ func (Base) printStructuredIndex(m map[string]interface{}, level int) {
 	}
 }
 
+// Matches the unix time and zone a commit was written at
+var commitTimeRegex = regexp.MustCompile(`^(\d+) ([+-]\d{4})$`)
+
+// Matches the unix time and zone following the author of a commit
+var authorTimeRegex = regexp.MustCompile(`^(.*) (\d+) ([+-]\d{4})$`)
+
 // GetCommit takes an OID and returns a pointer to a CommitObject
 func (Base) GetCommit(oid string) (*CommitObject, error) {
 	buf, t, err := data.GetObject(oid)
@@ -252,9 +558,14 @@ func (Base) GetCommit(oid string) (*CommitObject, error) {
 	}
 
 	var c CommitObject
-	fields := strings.Split(string(buf), "\n")
 	var parents []string
-	for _, field := range fields {
+	// The message is always last and may span several lines
+	header, message, _ := strings.Cut(string(buf), "\nmessage ")
+	if rest, ok := strings.CutPrefix(header, "message "); ok {
+		header, message = "", rest
+	}
+	c.Message = message
+	for _, field := range strings.Split(header, "\n") {
 		split := strings.SplitN(field, " ", 2)
 		if len(split) != 2 {
 			continue
@@ -266,10 +577,27 @@ func (Base) GetCommit(oid string) (*CommitObject, error) {
 		case "parent":
 			parents = append(parents, value)
 		case "time":
-			t, _ := time.Parse(time.Layout, value)
+			if match := commitTimeRegex.FindStringSubmatch(value); match != nil {
+				unix, _ := strconv.ParseInt(match[1], 10, 64)
+				zone, _ := time.Parse("-0700", match[2])
+				c.Timestamp = time.Unix(unix, 0).In(zone.Location())
+				break
+			}
+			// Older commits recorded the time with time.Layout, or before that in Go's default format possibly
+			// with a monotonic clock reading
+			t, err := time.Parse(time.Layout, value)
+			if err != nil {
+				value, _, _ = strings.Cut(value, " m=")
+				t, _ = time.Parse("2006-01-02 15:04:05.999999999 -0700 MST", value)
+			}
 			c.Timestamp = t
-		case "message":
-			c.Message = value
+		case "author":
+			c.Author = value
+			if match := authorTimeRegex.FindStringSubmatch(value); match != nil {
+				unix, _ := strconv.ParseInt(match[2], 10, 64)
+				zone, _ := time.Parse("-0700", match[3])
+				c.Author, c.AuthorTime = match[1], time.Unix(unix, 0).In(zone.Location())
+			}
 		default:
 			return nil, fmt.Errorf("unknown key %s", key)
 		}
@@ -279,35 +607,25 @@ func (Base) GetCommit(oid string) (*CommitObject, error) {
 }
 
 func (Base) printCommit(oid string, commit CommitObject, refs []string) {
+	fmt.Print(base.describeCommit(oid, commit, refs))
+}
+
+// describeCommit returns the default log output of a commit
+func (Base) describeCommit(oid string, commit CommitObject, refs []string) string {
 	oid, _ = base.GetOid(oid)
 	var refsStr string
 	if len(refs) > 0 {
 		refsStr = fmt.Sprintf(" <- (%s)", strings.Join(refs, ", "))
 	}
-	fmt.Printf("commit: %s%s\n", oid, refsStr)
-	fmt.Printf("message: \"%s\"\n\n", commit.Message)
+	s := fmt.Sprintf("commit: %s%s\n", oid, refsStr)
+	if commit.Author != "" {
+		s += fmt.Sprintf("author: %s\n", commit.Author)
+	}
+	return s + fmt.Sprintf("message: \"%s\"\n\n", commit.Message)
 }
 
 func (Base) GetOid(name string) (string, error) {
-	// Alias @ to HEAD
-	if name == "@" {
-		name = HEAD
-	}
-
-	for _, dir := range localRefDirs {
-		fp := filepath.Join(dir, name)
-		if ref, err := data.GetRef(fp, false); err == nil && ref != nil && len(ref.Value) > 0 {
-			ref, err = data.GetRef(fp, true)
-			if err != nil {
-				return "", err
-			}
-			return ref.Value, nil
-		}
-	}
-	if data.isValidSHA1(name) {
-		return name, nil
-	}
-	return "", RefNotFoundError{ref: name}
+	return revision.Resolve(name)
 }
 
 // Init initializes the gogit repository, and points HEAD toward a new branch "main"
@@ -315,12 +633,12 @@ func (Base) Init() error {
 	if err := data.Init(); err != nil {
 		return err
 	}
-	return data.UpdateRef(HEAD, &RefValue{true, BASE_BRANCH}, true)
+	return data.UpdateRef(HEAD, &RefValue{true, BASE_BRANCH}, true, "")
 }
 
-func (Base) ReadTree(treeOid string, updateWorkingDir bool) error {
+func (Base) ReadTree(treeOid string, updateWorkingDir bool, mode int) error {
 	return data.WithIndex(
-		func(_ map[string]string) (map[string]string, error) {
+		func(currentIndex map[string]string) (map[string]string, error) {
 			index := map[string]string{}
 			tree, err := base.GetTree(treeOid, ".")
 			if err != nil {
@@ -334,7 +652,7 @@ func (Base) ReadTree(treeOid string, updateWorkingDir bool) error {
 				return index, nil
 			}
 
-			return index, base.checkoutIndex(index)
+			return index, base.checkoutIndex(currentIndex, index, mode)
 		})
 }
 
@@ -345,28 +663,41 @@ func (Base) WriteTree(dir string) (string, error) {
 	}
 
 	// Write tree from index file
-	var writeTreeRecursive func(index map[string]interface{}) (string, error)
-	writeTreeRecursive = func(index map[string]interface{}) (string, error) {
-		var entries []TreeEntry
-		for name, value := range index {
-			var oid, _type string
-			if subdirIndex, ok := value.(map[string]interface{}); ok {
-				_type = TREE
-				oid, _ = writeTreeRecursive(subdirIndex)
-			} else {
-				_type = BLOB
-				oid = value.(string)
+	return base.writeStructuredTree(index)
+}
+
+// WriteTreeFrom writes the tree objects for a flat map of path to blob oid and returns the root tree oid
+func (Base) WriteTreeFrom(tree Tree) (string, error) {
+	return base.writeStructuredTree(base.structureIndex(tree))
+}
+
+func (Base) writeStructuredTree(index map[string]interface{}) (string, error) {
+	var entries []TreeEntry
+	for name, value := range index {
+		var oid, _type string
+		if subdirIndex, ok := value.(map[string]interface{}); ok {
+			_type = TREE
+			var err error
+			if oid, err = base.writeStructuredTree(subdirIndex); err != nil {
+				return "", err
 			}
-			entries = append(entries, TreeEntry{name, oid, _type})
+		} else {
+			_type = BLOB
+			oid = value.(string)
 		}
+		entries = append(entries, TreeEntry{name, oid, _type})
+	}
 
-		var tree string
-		for _, entry := range entries {
-			tree += fmt.Sprintf("%s %s %s\n", entry.Name, entry.Oid, entry.Type)
-		}
-		return data.HashObject([]byte(tree), TREE)
+	// Sort entries so the same content always hashes to the same tree
+	slices.SortFunc(entries, func(a, b TreeEntry) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	var tree string
+	for _, entry := range entries {
+		tree += fmt.Sprintf("%s %s %s\n", entry.Name, entry.Oid, entry.Type)
 	}
-	return writeTreeRecursive(index)
+	return data.HashObject([]byte(tree), TREE)
 }
 
 func (Base) GetTree(oid, basePath string) (Tree, error) {
@@ -408,14 +739,19 @@ func (Base) GetIndexTree() (Tree, error) {
 func (Base) GetWorkingTree() (Tree, error) {
 	res := make(Tree)
 	err := filepath.WalkDir(".", func(path string, d fs.DirEntry, e error) error {
-		if data.isIgnored(path) || d.IsDir() {
+		if e != nil {
+			return e
+		}
+		if data.isIgnored(path) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
 			return nil
 		}
-		buf, err := os.ReadFile(path)
-		if err != nil {
-			return err
+		if d.IsDir() {
+			return nil
 		}
-		oid, err := data.HashObject(buf, BLOB)
+		oid, err := base.hashWorkingFile(path)
 		if err != nil {
 			return err
 		}
@@ -433,6 +769,7 @@ func (Base) ReadTreeMerged(
 	headTreeOid string,
 	mergeTreeOid string,
 	updateWorkingDir bool,
+	mode int,
 ) error {
 	return data.WithIndex(
 		func(index map[string]string) (map[string]string, error) {
@@ -459,7 +796,7 @@ func (Base) ReadTreeMerged(
 			if !updateWorkingDir {
 				return mergedTree, nil
 			}
-			return mergedTree, base.checkoutIndex(mergedTree)
+			return mergedTree, base.checkoutIndex(index, mergedTree, mode)
 		})
 }
 
@@ -477,174 +814,778 @@ func (Base) Commit(message string, timestamp time.Time) (string, error) {
 	mergeHeadRef, _ := data.GetRef(MERGE_HEAD, true)
 	if mergeHeadRef.Value != "" {
 		parents = append(parents, mergeHeadRef.Value)
-		err = data.DeleteRef(MERGE_HEAD, false)
-		if err != nil {
-			return "", err
-		}
 	}
-	c := CommitObject{tree, parents, timestamp, message}
-	oid, err := data.HashObject([]byte(c.String()), COMMIT)
+	oid, err := base.writeCommit(CommitObject{tree, parents, base.author(), timestamp, timestamp, message})
 	if err != nil {
 		return "", err
 	}
-	err = data.UpdateRef(HEAD, &RefValue{false, oid}, true)
-	return oid, err
-}
 
-func (Base) Log(oid string) error {
-	refs := make(map[string][]string)
-
-	refIter, err := data.iterRefs("", true)
-	if err != nil {
-		return err
-	}
-	for refName, ref := range refIter {
-		refs[ref.Value] = append(refs[ref.Value], refName)
+	reason := "commit"
+	if len(parents) == 0 {
+		reason = "commit (initial)"
+	} else if len(parents) > 1 {
+		reason = "commit (merge)"
 	}
 
-	oid, _ = base.GetOid(oid)
-	for oidItr := range base.iterCommitsAndParents([]string{oid}) {
-		c, err := base.GetCommit(oidItr)
-		if err != nil {
-			return err
-		}
-		base.printCommit(oidItr, *c, refs[oidItr])
+	// HEAD only moves if nobody else committed in the meantime, and the merge is only concluded if it does
+	t := data.NewRefTransaction()
+	t.Update(HEAD, &RefValue{false, oid}, true, cmp.Or(headRef.Value, ZERO_OID), base.reflogMessage(reason, message))
+	if mergeHeadRef.Value != "" {
+		t.Delete(MERGE_HEAD, false, mergeHeadRef.Value)
 	}
-	return nil
+	return oid, t.Commit()
 }
 
-func (Base) Checkout(name string, isNew bool) error {
-	oid, err := base.GetOid(name)
-	if _, ok := err.(RefNotFoundError); err != nil {
-		if !ok || (ok && !isNew) {
-			return err
-		}
+// Amend replaces the commit at HEAD with one built from the current index. The new commit keeps the author and
+// parents of the one it replaces, and its message if none is given
+func (Base) Amend(message string, timestamp time.Time) (string, error) {
+	headRef, err := data.GetRef(HEAD, true)
+	if err != nil {
+		return "", err
+	}
+	if headRef.Value == "" {
+		return "", fmt.Errorf("no commit to amend")
 	}
 
-	if oid != "" {
-		c, err := base.GetCommit(oid)
-		if err != nil {
-			return err
-		}
+	mergeHeadRef, err := data.GetRef(MERGE_HEAD, true)
+	if err != nil {
+		return "", err
+	}
+	if mergeHeadRef.Value != "" {
+		return "", fmt.Errorf("cannot amend in the middle of a merge")
+	}
 
-		if err := base.ReadTree(c.TreeOid, true); err != nil {
-			return err
-		}
+	head, err := base.GetCommit(headRef.Value)
+	if err != nil {
+		return "", err
+	}
+	if message == "" {
+		message = head.Message
 	}
 
-	var headRef *RefValue
-	if base.isBranch(name) || oid == "" {
-		headRef = &RefValue{true, fmt.Sprintf("refs/heads/%s", name)}
-	} else {
-		headRef = &RefValue{false, oid}
+	tree, err := base.WriteTree(".")
+	if err != nil {
+		return "", err
 	}
-	return data.UpdateRef(HEAD, headRef, false)
-}
 
-// Reset moves HEAD to the oid passed as a parameter
-func (Base) Reset(oid string) error {
-	oid, err := base.GetOid(oid)
+	oid, err := base.writeCommit(CommitObject{tree, head.ParentOids, head.Author, head.AuthorTime, timestamp, message})
 	if err != nil {
-		return err
+		return "", err
 	}
-	return data.UpdateRef(HEAD, &RefValue{false, oid}, true)
+
+	t := data.NewRefTransaction()
+	t.Update(HEAD, &RefValue{false, oid}, true, headRef.Value, base.reflogMessage("commit (amend)", message))
+	return oid, t.Commit()
 }
 
-func (Base) CreateTag(name, oid string) error {
-	if oid == "" {
-		oid = "@"
-	}
-	return data.UpdateRef(fmt.Sprintf("refs/tags/%s", name), &RefValue{false, oid}, true)
+// author returns the identity recorded as the author of new commits
+func (Base) author() string {
+	name, email := data.Identity()
+	return fmt.Sprintf("%s <%s>", name, email)
 }
 
-func (Base) CreateBranch(name, baseName string) error {
-	refPath := filepath.Join(GOGIT_DIR, "refs/heads", name)
-	if _, err := os.Stat(refPath); err == nil {
-		return fmt.Errorf("branch already exists with name \"%s\"", name)
-	}
+// reflogMessage formats the reason of a ref update made for a commit as "<action>: <subject>"
+func (Base) reflogMessage(action, message string) string {
+	subject, _, _ := strings.Cut(message, "\n")
+	return fmt.Sprintf("%s: %s", action, subject)
+}
 
-	oid, err := base.GetOid(baseName)
+// describeHead returns the branch HEAD points to, or the oid it holds when detached
+func (Base) describeHead() (string, error) {
+	branch, err := base.GetBranch()
+	if err != nil || branch != "" {
+		return branch, err
+	}
+	headRef, err := data.GetRef(HEAD, true)
 	if err != nil {
-		return err
+		return "", err
 	}
-	return data.UpdateRef(filepath.Join("refs/heads", name), &RefValue{false, oid}, true)
+	return headRef.Value, nil
 }
 
-func (Base) GetBranch() (string, error) {
-	headRef, err := data.GetRef(HEAD, false)
-	if err != nil || !headRef.Symbolic {
+// writeCommit stores a commit object, records it in the commit graph and returns its oid
+func (Base) writeCommit(c CommitObject) (string, error) {
+	oid, err := data.HashObject([]byte(c.String()), COMMIT)
+	if err != nil {
 		return "", err
 	}
-	return filepath.Base(headRef.Value), nil
+	return oid, commitGraph.Add(oid, &c)
 }
 
-// Performs 3-way merge
-func (Base) Merge(oid string) error {
-	headRef, err := data.GetRef(HEAD, true)
+// RevList returns the commits selected by revs, which may contain ranges like A..B and A...B and
+// exclusions like ^A. HEAD is used when no revision is included
+func (Base) RevList(revs []string, firstParent bool, order int) (iter.Seq[string], error) {
+	include, exclude, err := revision.ParseRange(revs)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if len(include) == 0 {
+		head, err := base.GetOid(HEAD)
+		if err != nil {
+			return nil, err
+		}
+		include = append(include, head)
 	}
+	return base.walkCommits(include, exclude, firstParent, order), nil
+}
+
+// Log prints the commits in revs that pass the filters of opts, in the format it asks for
+func (Base) Log(revs []string, opts LogOptions) error {
+	refs := make(map[string][]string)
 
-	oid, err = base.GetOid(oid)
+	refIter, err := data.iterRefs("", true)
 	if err != nil {
 		return err
 	}
-	commit, err := base.GetCommit(oid)
-	if err != nil {
-		return err
+	for refName, ref := range refIter {
+		refs[ref.Value] = append(refs[ref.Value], refName)
 	}
 
-	mergeBaseOID, err := base.getMergeBase(oid, headRef.Value)
+	// Lanes can only be drawn if children come before their parents
+	if opts.Graph && opts.Order == ORDER_DEFAULT {
+		opts.Order = ORDER_TOPO
+	}
+	commits, err := base.RevList(revs, opts.FirstParent, opts.Order)
 	if err != nil {
 		return err
 	}
 
-	// Fast-forward merge
-	if mergeBaseOID == headRef.Value {
-		fmt.Println("fast-forward merge")
-		err := base.ReadTree(commit.TreeOid, true)
-		if err != nil {
+	var oids []string
+	selected := make(map[string]*CommitObject)
+	// Name of the followed file in each commit still to be visited, which differs between lines of history
+	// that renamed it and those that did not
+	followed := make(map[string]string)
+	for oid := range commits {
+		if opts.MaxCount > 0 && len(oids) == opts.MaxCount {
+			break
+		}
+		c, err := base.GetCommit(oid)
+		if err != nil {
+			return err
+		}
+		commitOpts := opts
+		if opts.Follow.Threshold > 0 {
+			commitOpts.Paths = []string{cmp.Or(followed[oid], opts.Paths[0])}
+		}
+		ok, err := base.logIncludes(c, commitOpts)
+		if err != nil {
 			return err
 		}
-		return data.UpdateRef(HEAD, &RefValue{false, oid}, true)
+		if ok {
+			oids = append(oids, oid)
+			selected[oid] = c
+		}
+
+		// Parents know the followed file by the name it had before this commit renamed it
+		if opts.Follow.Threshold > 0 {
+			for _, parentOid := range c.ParentOids {
+				if _, ok := followed[parentOid]; ok {
+					continue
+				}
+				if followed[parentOid], err = base.renamedFrom(c, parentOid, commitOpts.Paths[0], opts.Follow); err != nil {
+					return err
+				}
+			}
+		}
 	}
 
-	headCommit, err := base.GetCommit(headRef.Value)
+	// The commit limit applies before reversing, as it does in git
+	if opts.Reverse {
+		slices.Reverse(oids)
+	}
+	if !opts.Graph {
+		for _, oid := range oids {
+			if opts.Format == "" {
+				base.printCommit(oid, *selected[oid], refs[oid])
+				continue
+			}
+			fmt.Println(base.formatCommit(opts.Format, oid, *selected[oid], refs[oid]))
+		}
+		return nil
+	}
+
+	graph := &LogGraph{}
+	for _, oid := range oids {
+		c := selected[oid]
+		text := base.describeCommit(oid, *c, refs[oid])
+		if opts.Format != "" {
+			text = base.formatCommit(opts.Format, oid, *c, refs[oid]) + "\n"
+		}
+
+		// Lanes only lead to parents that are printed as well
+		parents := slices.DeleteFunc(slices.Clone(c.ParentOids), func(parent string) bool {
+			return selected[parent] == nil
+		})
+		if opts.FirstParent {
+			parents = parents[:min(len(parents), 1)]
+		}
+
+		row, padding, connectors := graph.Commit(oid, parents)
+		lines := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+		fmt.Println(strings.TrimRight(row+" "+lines[0], " "))
+		for _, line := range lines[1:] {
+			fmt.Println(strings.TrimRight(fmt.Sprintf("%-*s %s", len(row), padding, line), " "))
+		}
+		for _, connector := range connectors {
+			fmt.Println(connector)
+		}
+	}
+	return nil
+}
+
+// logIncludes returns true if the commit passes every filter of opts
+func (Base) logIncludes(c *CommitObject, opts LogOptions) (bool, error) {
+	switch {
+	case opts.Merges == MERGES_ONLY && len(c.ParentOids) < 2,
+		opts.Merges == MERGES_EXCLUDED && len(c.ParentOids) > 1,
+		!opts.Since.IsZero() && c.Timestamp.Before(opts.Since),
+		!opts.Until.IsZero() && c.Timestamp.After(opts.Until),
+		opts.Author != nil && !opts.Author.MatchString(c.Author),
+		opts.Grep != nil && !opts.Grep.MatchString(c.Message):
+		return false, nil
+	}
+	if len(opts.Paths) > 0 {
+		if ok, err := base.touchesPaths(c, opts.Paths); err != nil || !ok {
+			return false, err
+		}
+	}
+	if opts.Pickaxe == "" && opts.PickaxeRegex == nil {
+		return true, nil
+	}
+	return base.pickaxeMatches(c, opts)
+}
+
+// pickaxeMatches returns true if the commit changed the number of occurrences of opts.Pickaxe in a file,
+// or added or removed a line matching opts.PickaxeRegex. As in git, merges have no diff to search
+func (Base) pickaxeMatches(c *CommitObject, opts LogOptions) (bool, error) {
+	if len(c.ParentOids) > 1 {
+		return false, nil
+	}
+	tree, err := base.GetTree(c.TreeOid, "")
+	if err != nil {
+		return false, err
+	}
+	parentTree := Tree{}
+	if len(c.ParentOids) == 1 {
+		parent, err := base.GetCommit(c.ParentOids[0])
+		if err != nil {
+			return false, err
+		}
+		if parentTree, err = base.GetTree(parent.TreeOid, ""); err != nil {
+			return false, err
+		}
+	}
+
+	readBlob := func(oid string) (string, error) {
+		if oid == "" {
+			return "", nil
+		}
+		buf, _, err := data.GetObject(oid)
+		return string(buf), err
+	}
+
+	for path, oids := range diff.compareTrees(parentTree, tree) {
+		if oids[0] == oids[1] || (len(opts.Paths) > 0 && !base.matchesPaths(path, opts.Paths)) {
+			continue
+		}
+		before, err := readBlob(oids[0])
+		if err != nil {
+			return false, err
+		}
+		after, err := readBlob(oids[1])
+		if err != nil {
+			return false, err
+		}
+
+		if opts.Pickaxe != "" && strings.Count(before, opts.Pickaxe) != strings.Count(after, opts.Pickaxe) {
+			return true, nil
+		}
+		if opts.PickaxeRegex == nil {
+			continue
+		}
+
+		// Files that were added or deleted have every line changed
+		var changed []string
+		if oids[0] == "" || oids[1] == "" {
+			changed = strings.Split(before+after, "\n")
+		} else {
+			hunks, err := patch.getHunks(path, oids[0], oids[1])
+			if err != nil {
+				return false, err
+			}
+			for _, h := range hunks {
+				for _, line := range h.Lines {
+					if line[0] == '+' || line[0] == '-' {
+						changed = append(changed, strings.TrimSuffix(line[1:], "\n"))
+					}
+				}
+			}
+		}
+		if slices.ContainsFunc(changed, opts.PickaxeRegex.MatchString) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// touchesPaths returns true if the commit changed any file under paths. Like git, a merge is only
+// counted when it differs from every one of its parents there
+func (Base) touchesPaths(c *CommitObject, paths []string) (bool, error) {
+	tree, err := base.GetTree(c.TreeOid, "")
+	if err != nil {
+		return false, err
+	}
+	parentTrees := []Tree{{}}
+	if len(c.ParentOids) > 0 {
+		parentTrees = nil
+	}
+	for _, parentOid := range c.ParentOids {
+		parent, err := base.GetCommit(parentOid)
+		if err != nil {
+			return false, err
+		}
+		parentTree, err := base.GetTree(parent.TreeOid, "")
+		if err != nil {
+			return false, err
+		}
+		parentTrees = append(parentTrees, parentTree)
+	}
+
+	for _, parentTree := range parentTrees {
+		changed := false
+		for path, oids := range diff.compareTrees(parentTree, tree) {
+			if oids[0] != oids[1] && base.matchesPaths(path, paths) {
+				changed = true
+				break
+			}
+		}
+		if !changed {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// renamedFrom returns the path a commit renamed or copied path from relative to one of its parents, or
+// path if it did neither. Renames are only looked for when the commit changed path
+func (Base) renamedFrom(c *CommitObject, parentOid, path string, opts RenameOptions) (string, error) {
+	parentTree, err := base.getCommitTree(parentOid)
+	if err != nil {
+		return "", err
+	}
+	tree, err := base.GetTree(c.TreeOid, "")
+	if err != nil {
+		return "", err
+	}
+	if tree[path] == parentTree[path] {
+		return path, nil
+	}
+
+	changes, err := diff.iterChangedFiles(parentTree, tree, opts)
+	if err != nil {
+		return "", err
+	}
+	for change := range changes {
+		if change.Path == path && (change.Action == ACTION_RENAMED || change.Action == ACTION_COPIED) {
+			return change.FromPath, nil
+		}
+	}
+	return path, nil
+}
+
+// Matches the placeholders understood by formatCommit
+var formatPlaceholderRegex = regexp.MustCompile(`%(an|ae|ad|ar|at|H|h|T|t|P|p|s|b|d|D|n|%)`)
+
+// formatCommit expands the placeholders of format for a commit: %H/%h commit oid, %T/%t tree oid,
+// %P/%p parent oids, %an/%ae author name and email, %ad/%ar/%at date, %s subject, %b body,
+// %d/%D refs, %n newline and %% a percent sign. Lowercase oid placeholders are abbreviated
+func (Base) formatCommit(format, oid string, c CommitObject, refs []string) string {
+	name, email, _ := strings.Cut(c.Author, " <")
+	email = strings.TrimSuffix(email, ">")
+	subject, body, _ := strings.Cut(c.Message, "\n")
+	date := cmp.Or(c.AuthorTime, c.Timestamp)
+
+	abbrev := func(oids ...string) string {
+		short := make([]string, len(oids))
+		for i, oid := range oids {
+			short[i] = oid[:min(len(oid), ABBREV_LENGTH)]
+		}
+		return strings.Join(short, " ")
+	}
+
+	return formatPlaceholderRegex.ReplaceAllStringFunc(format, func(placeholder string) string {
+		switch placeholder[1:] {
+		case "H":
+			return oid
+		case "h":
+			return abbrev(oid)
+		case "T":
+			return c.TreeOid
+		case "t":
+			return abbrev(c.TreeOid)
+		case "P":
+			return strings.Join(c.ParentOids, " ")
+		case "p":
+			return abbrev(c.ParentOids...)
+		case "an":
+			return name
+		case "ae":
+			return email
+		case "ad":
+			return date.Format(time.RFC1123Z)
+		case "ar":
+			return relativeDate(date, time.Now())
+		case "at":
+			return strconv.FormatInt(date.Unix(), 10)
+		case "s":
+			return subject
+		case "b":
+			return strings.TrimLeft(body, "\n")
+		case "d":
+			if len(refs) == 0 {
+				return ""
+			}
+			return fmt.Sprintf(" (%s)", strings.Join(refs, ", "))
+		case "D":
+			return strings.Join(refs, ", ")
+		case "n":
+			return "\n"
+		}
+		return "%"
+	})
+}
+
+func (Base) Checkout(name string, isNew bool, mode int) error {
+	// @{-n} switches back to the branch itself rather than detaching at its commit
+	if refName, selector, ok := reflog.ParseRevision(name); ok && refName == "" && strings.HasPrefix(selector, "-") {
+		n, err := strconv.Atoi(selector[1:])
+		if err != nil {
+			return fmt.Errorf("invalid revision \"%s\"", name)
+		}
+		if name, err = revision.PreviousBranch(n); err != nil {
+			return err
+		}
+	}
+
+	oid, err := base.GetOid(name)
+	if _, ok := err.(RefNotFoundError); err != nil {
+		if !ok || (ok && !isNew) {
+			return err
+		}
+	}
+
+	from, err := base.describeHead()
 	if err != nil {
 		return err
 	}
-	mergeBaseCommit, err := base.GetCommit(mergeBaseOID)
+
+	if oid != "" {
+		c, err := base.GetCommit(oid)
+		if err != nil {
+			return err
+		}
+
+		if err := base.ReadTree(c.TreeOid, true, mode); err != nil {
+			return err
+		}
+	}
+
+	var headRef *RefValue
+	if base.isBranch(name) || oid == "" {
+		headRef = &RefValue{true, fmt.Sprintf("refs/heads/%s", name)}
+	} else {
+		headRef = &RefValue{false, oid}
+	}
+	return data.UpdateRef(HEAD, headRef, false, fmt.Sprintf("checkout: moving from %s to %s", from, name))
+}
+
+// Reset moves HEAD to the oid passed as a parameter. RESET_MIXED also resets the index to the
+// commit's tree and RESET_HARD additionally overwrites the working directory
+func (Base) Reset(rev string, mode int) error {
+	oid, err := base.GetOid(rev)
 	if err != nil {
 		return err
 	}
-	err = data.UpdateRef(MERGE_HEAD, &RefValue{false, oid}, true)
+
+	if mode != RESET_SOFT {
+		c, err := base.GetCommit(oid)
+		if err != nil {
+			return err
+		}
+		if err = base.ReadTree(c.TreeOid, mode == RESET_HARD, CHECKOUT_FORCE); err != nil {
+			return err
+		}
+	}
+	return data.UpdateRef(HEAD, &RefValue{false, oid}, true, fmt.Sprintf("reset: moving to %s", rev))
+}
+
+// getCommitTree resolves a revision to a commit and returns its flattened tree.
+// An unborn HEAD resolves to an empty tree
+func (Base) getCommitTree(rev string) (Tree, error) {
+	var oid string
+	if rev == "@" || rev == HEAD {
+		headRef, err := data.GetRef(HEAD, true)
+		if err != nil {
+			return nil, err
+		}
+		oid = headRef.Value
+	} else {
+		var err error
+		if oid, err = base.GetOid(rev); err != nil {
+			return nil, err
+		}
+	}
+
+	if oid == "" {
+		return Tree{}, nil
+	}
+	c, err := base.GetCommit(oid)
+	if err != nil {
+		return nil, err
+	}
+	return base.GetTree(c.TreeOid, "")
+}
+
+// matchesPaths returns true if path is one of the pathspecs or lies inside a directory they name
+func (Base) matchesPaths(path string, pathspecs []string) bool {
+	path = filepath.Clean(path)
+	for _, spec := range pathspecs {
+		spec = filepath.Clean(spec)
+		if spec == "." || path == spec || strings.HasPrefix(path, spec+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Restore restores paths in the index and/or working directory from source without moving HEAD.
+// Without a source, the working directory is restored from the index and the index from HEAD
+func (Base) Restore(source string, paths []string, staged, worktree bool) error {
+	if !staged && !worktree {
+		worktree = true
+	}
+
+	var sourceTree Tree
+	if source != "" || staged {
+		if source == "" {
+			source = "@"
+		}
+		tree, err := base.getCommitTree(source)
+		if err != nil {
+			return err
+		}
+		sourceTree = tree
+	}
+
+	return data.WithIndex(
+		func(index map[string]string) (map[string]string, error) {
+			if sourceTree == nil {
+				sourceTree = maps.Clone(index)
+			}
+
+			for _, spec := range paths {
+				matched := false
+				for path := range diff.compareTrees(sourceTree, index) {
+					if base.matchesPaths(path, []string{spec}) {
+						matched = true
+						break
+					}
+				}
+				if !matched {
+					return nil, fmt.Errorf("pathspec \"%s\" did not match any file(s) known to gogit", spec)
+				}
+			}
+
+			newIndex := maps.Clone(index)
+			for path, oids := range diff.compareTrees(sourceTree, index) {
+				if !base.matchesPaths(path, paths) {
+					continue
+				}
+
+				oid, tracked := oids[0], oids[1] != ""
+				if staged {
+					if oid == "" {
+						delete(newIndex, path)
+					} else {
+						newIndex[path] = oid
+					}
+				}
+
+				if !worktree {
+					continue
+				}
+				if oid == "" {
+					// Files missing from the source are only removed if gogit knows about them
+					if tracked {
+						if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+							return nil, err
+						}
+						data.removeEmptyDirs(filepath.Dir(path))
+					}
+					continue
+				}
+				if err := os.MkdirAll(filepath.Dir(path), FP); err != nil {
+					return nil, err
+				}
+				if err := base.writeWorkingFile(path, oid); err != nil {
+					return nil, err
+				}
+			}
+			return newIndex, nil
+		})
+}
+
+// selectPatchTargets returns the paths matching the pathspecs that are modified between two trees
+func (Base) selectPatchTargets(from, to Tree, paths []string) []string {
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+
+	var targets []string
+	for path, oids := range diff.compareTrees(from, to) {
+		if oids[0] != "" && oids[1] != "" && oids[0] != oids[1] && base.matchesPaths(path, paths) {
+			targets = append(targets, path)
+		}
+	}
+	return targets
+}
+
+// AddPatch interactively stages hunks of the changes between the index and the working directory
+func (Base) AddPatch(paths ...string) error {
+	workingTree, err := base.GetWorkingTree()
 	if err != nil {
 		return err
 	}
-	return base.ReadTreeMerged(mergeBaseCommit.TreeOid, headCommit.TreeOid, commit.TreeOid, true)
+
+	in := bufio.NewReader(os.Stdin)
+	return data.WithIndex(
+		func(index map[string]string) (map[string]string, error) {
+			for _, path := range base.selectPatchTargets(index, workingTree, paths) {
+				content, changed, quit, err := patch.SelectHunks(in, path, index[path], workingTree[path], "Stage")
+				if err != nil {
+					return nil, err
+				}
+				if changed {
+					if index[path], err = data.HashObject(content, BLOB); err != nil {
+						return nil, err
+					}
+				}
+				if quit {
+					break
+				}
+			}
+			return index, nil
+		})
 }
 
-func (Base) getMergeBase(oid1, oid2 string) (string, error) {
-	oid1Parents := ds.NewSet([]string{})
-	for parent := range base.iterCommitsAndParents([]string{oid1}) {
-		oid1Parents.Add(parent)
+// ResetPatch interactively unstages hunks of the changes between rev and the index
+func (Base) ResetPatch(rev string, paths ...string) error {
+	tree, err := base.getCommitTree(rev)
+	if err != nil {
+		return err
 	}
 
-	for parent := range base.iterCommitsAndParents([]string{oid2}) {
-		if oid1Parents.Includes(parent) {
-			return parent, nil
+	in := bufio.NewReader(os.Stdin)
+	return data.WithIndex(
+		func(index map[string]string) (map[string]string, error) {
+			for _, path := range base.selectPatchTargets(index, tree, paths) {
+				content, changed, quit, err := patch.SelectHunks(in, path, index[path], tree[path], "Unstage")
+				if err != nil {
+					return nil, err
+				}
+				if changed {
+					if index[path], err = data.HashObject(content, BLOB); err != nil {
+						return nil, err
+					}
+				}
+				if quit {
+					break
+				}
+			}
+			return index, nil
+		})
+}
+
+// RestorePatch interactively discards hunks of the changes between the working directory and
+// source, or the index if no source is given
+func (Base) RestorePatch(source string, paths ...string) error {
+	var sourceTree Tree
+	var err error
+	if source == "" {
+		sourceTree, err = base.GetIndexTree()
+	} else {
+		sourceTree, err = base.getCommitTree(source)
+	}
+	if err != nil {
+		return err
+	}
+
+	workingTree, err := base.GetWorkingTree()
+	if err != nil {
+		return err
+	}
+
+	in := bufio.NewReader(os.Stdin)
+	for _, path := range base.selectPatchTargets(workingTree, sourceTree, paths) {
+		content, changed, quit, err := patch.SelectHunks(in, path, workingTree[path], sourceTree[path], "Discard")
+		if err != nil {
+			return err
+		}
+		if changed {
+			if err = os.WriteFile(path, content, FP); err != nil {
+				return err
+			}
+		}
+		if quit {
+			break
 		}
 	}
-	return "", fmt.Errorf("no common ancestor found for oids %s and %s", oid1, oid2)
+	return nil
+}
+
+func (Base) CreateTag(name, oid string) error {
+	if oid == "" {
+		oid = "@"
+	}
+	oid, err := base.GetOid(oid)
+	if err != nil {
+		return err
+	}
+	return data.UpdateRef(fmt.Sprintf("refs/tags/%s", name), &RefValue{false, oid}, true, "")
+}
+
+func (Base) CreateBranch(name, baseName string) error {
+	if base.isBranch(name) {
+		return fmt.Errorf("branch already exists with name \"%s\"", name)
+	}
+
+	oid, err := base.GetOid(baseName)
+	if err != nil {
+		return err
+	}
+	return data.UpdateRef(filepath.Join("refs/heads", name), &RefValue{false, oid}, true, fmt.Sprintf("branch: Created from %s", baseName))
+}
+
+func (Base) GetBranch() (string, error) {
+	headRef, err := data.GetRef(HEAD, false)
+	if err != nil || !headRef.Symbolic {
+		return "", err
+	}
+	return filepath.Base(headRef.Value), nil
 }
 
-func (Base) Rebase(oid string) error {
+// Performs 3-way merge
+func (Base) Merge(name string, mode int) error {
 	headRef, err := data.GetRef(HEAD, true)
 	if err != nil {
 		return err
 	}
 
-	oid, err = base.GetOid(oid)
+	oid, err := base.GetOid(name)
+	if err != nil {
+		return err
+	}
+	commit, err := base.GetCommit(oid)
 	if err != nil {
 		return err
 	}
@@ -654,13 +1595,61 @@ func (Base) Rebase(oid string) error {
 		return err
 	}
 
-	// commits in current branch, not in the history of OID
-	commitOIDs := base.getRebaseCommits(oid, headRef.Value)
-	err = data.UpdateRef(HEAD, &RefValue{false, oid}, true)
+	// Fast-forward merge
+	if mergeBaseOID == headRef.Value {
+		fmt.Println("fast-forward merge")
+		err := base.ReadTree(commit.TreeOid, true, mode)
+		if err != nil {
+			return err
+		}
+		return data.UpdateRef(HEAD, &RefValue{false, oid}, true, fmt.Sprintf("merge %s: Fast-forward", name))
+	}
+
+	headCommit, err := base.GetCommit(headRef.Value)
+	if err != nil {
+		return err
+	}
+	mergeBaseCommit, err := base.GetCommit(mergeBaseOID)
+	if err != nil {
+		return err
+	}
+	err = data.UpdateRef(MERGE_HEAD, &RefValue{false, oid}, true, "")
+	if err != nil {
+		return err
+	}
+	return base.ReadTreeMerged(mergeBaseCommit.TreeOid, headCommit.TreeOid, commit.TreeOid, true, mode)
+}
+
+func (Base) getMergeBase(oid1, oid2 string) (string, error) {
+	mergeBase, ok, err := commitGraph.MergeBase(oid1, oid2)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", fmt.Errorf("no common ancestor found for oids %s and %s", oid1, oid2)
+	}
+	return mergeBase, nil
+}
+
+func (Base) Rebase(name string) error {
+	headRef, err := data.GetRef(HEAD, true)
 	if err != nil {
 		return err
 	}
 
+	oid, err := base.GetOid(name)
+	if err != nil {
+		return err
+	}
+
+	mergeBaseOID, err := base.getMergeBase(oid, headRef.Value)
+	if err != nil {
+		return err
+	}
+
+	// commits in current branch, not in the history of OID
+	commitOIDs := base.getRebaseCommits(oid, headRef.Value)
+
 	headCommit, err := base.GetCommit(headRef.Value)
 	if err != nil {
 		return err
@@ -670,20 +1659,33 @@ func (Base) Rebase(oid string) error {
 		return err
 	}
 
+	// The rebased commits are chained onto oid, and HEAD is only moved once all of them are written
+	tip := oid
 	for _, commitOID := range commitOIDs {
 		commit, err := base.GetCommit(commitOID)
 		if err != nil {
 			return err
 		}
-		if err = base.ReadTreeMerged(mergeBaseCommit.TreeOid, headCommit.TreeOid, commit.TreeOid, true); err != nil {
+		if err = base.ReadTreeMerged(mergeBaseCommit.TreeOid, headCommit.TreeOid, commit.TreeOid, true, CHECKOUT_SAFE); err != nil {
 			return err
 		}
 
-		if _, err = base.Commit(commit.Message, commit.Timestamp); err != nil {
+		tree, err := base.WriteTree(".")
+		if err != nil {
+			return err
+		}
+		rebased := CommitObject{tree, []string{tip}, commit.Author, commit.AuthorTime, time.Now(), commit.Message}
+		if tip, err = base.writeCommit(rebased); err != nil {
 			return err
 		}
 	}
 
+	t := data.NewRefTransaction()
+	t.Update(HEAD, &RefValue{false, tip}, true, headRef.Value, fmt.Sprintf("rebase: onto %s", name))
+	if err = t.Commit(); err != nil {
+		return err
+	}
+
 	// Garbage collect unreachable old commits
 	_, err = base.GC()
 	return err
@@ -695,11 +1697,7 @@ func (Base) Add(filenames ...string) error {
 			return nil
 		}
 
-		f, err := os.ReadFile(filename)
-		if err != nil {
-			return err
-		}
-		oid, err := data.HashObject(f, BLOB)
+		oid, err := base.hashWorkingFile(filename)
 		if err != nil {
 			return err
 		}
@@ -709,7 +1707,13 @@ func (Base) Add(filenames ...string) error {
 
 	addDir := func(filename string, index map[string]string) error {
 		return filepath.WalkDir(filename, func(path string, d fs.DirEntry, e error) error {
+			if e != nil {
+				return e
+			}
 			if d.IsDir() {
+				if path != filename && data.isIgnored(path) {
+					return fs.SkipDir
+				}
 				return nil
 			}
 			return addFile(path, index)
@@ -729,14 +1733,186 @@ func (Base) Add(filenames ...string) error {
 							return nil, err
 						}
 					}
+				} else if os.IsNotExist(err) && base.stageDeletions(filename, index) {
+					continue
+				} else {
+					return nil, err
+				}
+			}
+			return index, nil
+		})
+}
+
+// stageDeletions removes the index entries matching path. Returns false if nothing was tracked there
+func (Base) stageDeletions(path string, index map[string]string) bool {
+	removed := false
+	for trackedPath := range index {
+		if base.matchesPaths(trackedPath, []string{path}) {
+			delete(index, trackedPath)
+			removed = true
+		}
+	}
+	return removed
+}
+
+// AddAll stages every new, modified and deleted file matching the pathspecs, or the whole
+// working directory if none are given
+func (Base) AddAll(paths ...string) error {
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	return base.stageChanges(paths, true)
+}
+
+// StageTracked stages the modifications and deletions of every tracked file
+func (Base) StageTracked() error {
+	return base.stageChanges([]string{"."}, false)
+}
+
+func (Base) stageChanges(paths []string, includeUntracked bool) error {
+	workingTree, err := base.GetWorkingTree()
+	if err != nil {
+		return err
+	}
+
+	return data.WithIndex(
+		func(index map[string]string) (map[string]string, error) {
+			for path, oids := range diff.compareTrees(index, workingTree) {
+				if !base.matchesPaths(path, paths) {
+					continue
+				}
+
+				indexOid, workingOid := oids[0], oids[1]
+				if indexOid == "" && !includeUntracked {
+					continue
+				}
+				if workingOid != "" {
+					index[path] = workingOid
+					continue
+				}
+
+				// Tracked files matching an ignore rule are still tracked
+				if _, err := os.Stat(path); err == nil && indexOid != "" {
+					oid, err := base.hashWorkingFile(path)
+					if err != nil {
+						return nil, err
+					}
+					index[path] = oid
 				} else {
+					delete(index, path)
+				}
+			}
+			return index, nil
+		})
+}
+
+// Remove drops paths from the index and, unless cached is set, from the working directory.
+// Files with local modifications are only removed from disk when force is set
+func (Base) Remove(paths []string, cached, recursive, force bool) error {
+	return data.WithIndex(
+		func(index map[string]string) (map[string]string, error) {
+			var toRemove []string
+			for _, spec := range paths {
+				matched := false
+				for path := range index {
+					if !base.matchesPaths(path, []string{spec}) {
+						continue
+					}
+					if filepath.Clean(spec) != path && !recursive {
+						return nil, fmt.Errorf("not removing \"%s\" recursively without -r", spec)
+					}
+					matched = true
+					toRemove = append(toRemove, path)
+				}
+				if !matched {
+					return nil, fmt.Errorf("pathspec \"%s\" did not match any files", spec)
+				}
+			}
+
+			if !cached && !force {
+				var modified []string
+				for _, path := range toRemove {
+					if _, err := os.Stat(path); err != nil {
+						continue
+					}
+					oid, err := base.hashWorkingFile(path)
+					if err != nil {
+						return nil, err
+					}
+					if oid != index[path] {
+						modified = append(modified, path)
+					}
+				}
+				if len(modified) > 0 {
+					return nil, fmt.Errorf(
+						"the following files have local modifications:\n\t%s\nuse --cached to keep the files, or --force to remove them",
+						strings.Join(modified, "\n\t"),
+					)
+				}
+			}
+
+			for _, path := range toRemove {
+				delete(index, path)
+				if cached {
+					continue
+				}
+				if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
 					return nil, err
 				}
+				data.removeEmptyDirs(filepath.Dir(path))
 			}
 			return index, nil
 		})
 }
 
+// Move renames a tracked file or directory on disk and in the index. If the index cannot be
+// written the rename is undone so both stay in sync
+func (Base) Move(src, dst string) error {
+	src, dst = filepath.Clean(src), filepath.Clean(dst)
+	if info, err := os.Stat(dst); err == nil {
+		if !info.IsDir() {
+			return fmt.Errorf("destination \"%s\" already exists", dst)
+		}
+		dst = filepath.Join(dst, filepath.Base(src))
+		if _, err := os.Stat(dst); err == nil {
+			return fmt.Errorf("destination \"%s\" already exists", dst)
+		}
+	}
+
+	renamed := false
+	err := data.WithIndex(
+		func(index map[string]string) (map[string]string, error) {
+			moved := false
+			for path, oid := range maps.Clone(index) {
+				if !base.matchesPaths(path, []string{src}) {
+					continue
+				}
+				delete(index, path)
+				index[filepath.Join(dst, strings.TrimPrefix(path, src))] = oid
+				moved = true
+			}
+			if !moved {
+				return nil, fmt.Errorf("\"%s\" is not tracked by gogit", src)
+			}
+
+			if err := os.MkdirAll(filepath.Dir(dst), FP); err != nil {
+				return nil, err
+			}
+			if err := os.Rename(src, dst); err != nil {
+				return nil, err
+			}
+			renamed = true
+			return index, nil
+		})
+
+	if err != nil && renamed {
+		if undoErr := os.Rename(dst, src); undoErr != nil {
+			return fmt.Errorf("%w (failed to undo rename: %s)", err, undoErr)
+		}
+	}
+	return err
+}
+
 func (Base) getRebaseCommits(oid1, oid2 string) []string {
 	oid1Parents := ds.NewSet([]string{})
 	for parent := range base.iterCommitsAndParents([]string{oid1}) {
@@ -756,28 +1932,55 @@ func (Base) getRebaseCommits(oid1, oid2 string) []string {
 func (Base) GC() (int, error) {
 	reachable := ds.NewSet([]string{})
 
+	// Parents and trees are looked up in the commit graph rather than parsed from every commit
+	graph, err := commitGraph.view()
+	if err != nil {
+		return 0, err
+	}
+	markHistory := func(oids []string) error {
+		commitOIDs, err := graph.ancestors(oids, 0)
+		if err != nil {
+			return err
+		}
+		visited := ds.NewSet([]string{})
+		for _, oid := range commitOIDs {
+			reachable.Add(oid)
+			entry, err := graph.get(oid)
+			if err != nil {
+				return err
+			}
+			err = base.mapObjectsInTree(entry.Tree, &visited, func(oid string) error {
+				reachable.Add(oid)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
 	// Iterate over all refs
 	refIter, err := data.iterRefs("heads", true)
 	if err != nil {
 		return 0, err
 	}
 
+	var tips []string
 	for _, ref := range refIter {
-		// Get all commits reachable from ref
-		commitOIDs := []string{}
-		for commitOID := range base.iterCommitsAndParents([]string{ref.Value}) {
-			commitOIDs = append(commitOIDs, commitOID)
-		}
+		tips = append(tips, ref.Value)
+	}
 
-		// For every object reachable from the commits, mark it
-		err := base.MapObjectsInCommits(commitOIDs, func(oid string) error {
-			reachable.Add(oid)
-			return nil
-		})
+	// Commits recorded in reflogs, including stash entries, are kept so they can still be recovered
+	reflogOids, err := reflog.ReachableOids()
+	if err != nil {
+		return 0, err
+	}
+	tips = append(tips, reflogOids...)
 
-		if err != nil {
-			return 0, err
-		}
+	// For every object reachable from the commits, mark it
+	if err = markHistory(tips); err != nil {
+		return 0, err
 	}
 
 	// We also don't want to delete anything reachable from the index
@@ -799,7 +2002,12 @@ func (Base) GC() (int, error) {
 		}
 		return nil
 	})
-	return unreachable, err
+	if err != nil {
+		return 0, err
+	}
+
+	// Pruned commits are dropped from the commit graph and reachable ones missing from it are added
+	return unreachable, commitGraph.Write(tips)
 }
 
 func (Base) K() error {
@@ -819,7 +2027,7 @@ func (Base) K() error {
 		}
 	}
 
-	for oid := range base.iterCommitsAndParents(oids.ToArray()) {
+	for oid := range base.walkCommits(oids.ToArray(), nil, false, ORDER_DEFAULT) {
 		c, _ := base.GetCommit(oid)
 		dot += fmt.Sprintf("\"%s\" [shape=box style=filled label=\"%s\"]\n", oid, oid[:10])
 		for _, parent := range c.ParentOids {
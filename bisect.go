@@ -0,0 +1,291 @@
+package main
+
+import (
+	"fmt"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	ds "local/gogit/data-structures"
+)
+
+type Bisect struct{}
+
+// Namespacing
+var bisect Bisect
+
+// Files holding the state of a bisection
+const (
+	BISECT_START = "BISECT_START" // Branch or oid checked out before bisecting
+	BISECT_BAD   = "BISECT_BAD"
+	BISECT_GOOD  = "BISECT_GOOD" // One oid per line
+	BISECT_SKIP  = "BISECT_SKIP" // One oid per line
+	BISECT_LOG   = "BISECT_LOG"
+)
+
+// Prefix of the lines of the bisect log that are replayed
+const BISECT_LOG_COMMAND = "gogit bisect "
+
+// Bisection terms
+const (
+	BISECT_TERM_GOOD = "good"
+	BISECT_TERM_BAD  = "bad"
+	BISECT_TERM_SKIP = "skip"
+)
+
+// BisectStep is the outcome of narrowing down the bisection
+type BisectStep struct {
+	Oid       string   // Commit checked out to be tested next
+	Remaining int      // Commits left to test after Oid
+	Steps     int      // Rough number of tests left after Oid
+	FirstBad  string   // Set once the first bad commit is known
+	Skipped   []string // Set when only skipped commits are left, any of which may be the first bad one
+}
+
+func (Bisect) path(name string) string {
+	return filepath.Join(GOGIT_ROOT, name)
+}
+
+func (Bisect) InProgress() bool {
+	_, err := os.Stat(bisect.path(BISECT_START))
+	return err == nil
+}
+
+func (Bisect) read(name string) ([]string, error) {
+	buf, err := os.ReadFile(bisect.path(name))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(string(buf)), nil
+}
+
+func (Bisect) appendTo(name, line string) error {
+	f, err := os.OpenFile(bisect.path(name), os.O_APPEND|os.O_CREATE|os.O_WRONLY, FP)
+	if err != nil {
+		return err
+	}
+	if _, err = fmt.Fprintln(f, line); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// describe returns "[oid] subject" as written to the bisect log
+func (Bisect) describe(oid string) (string, error) {
+	c, err := base.GetCommit(oid)
+	if err != nil {
+		return "", err
+	}
+	subject, _, _ := strings.Cut(c.Message, "\n")
+	return fmt.Sprintf("[%s] %s", oid, subject), nil
+}
+
+// Start begins a bisection, remembering what is checked out so that Reset can return to it
+func (Bisect) Start() error {
+	if bisect.InProgress() {
+		return fmt.Errorf("already bisecting, run \"gogit bisect reset\" first")
+	}
+	start, err := base.describeHead()
+	if err != nil {
+		return err
+	}
+	if start == "" {
+		return fmt.Errorf("cannot bisect without a commit checked out")
+	}
+	if err = os.WriteFile(bisect.path(BISECT_START), []byte(start), FP); err != nil {
+		return err
+	}
+	return bisect.appendTo(BISECT_LOG, BISECT_LOG_COMMAND+"start")
+}
+
+// Mark records rev as good, bad or skipped. Only one commit is bad at a time, marking another
+// one replaces it
+func (Bisect) Mark(term, rev string) error {
+	if !bisect.InProgress() {
+		return fmt.Errorf("not bisecting, run \"gogit bisect start\" first")
+	}
+	oid, err := base.GetOid(rev)
+	if err != nil {
+		return err
+	}
+	description, err := bisect.describe(oid)
+	if err != nil {
+		return err
+	}
+
+	switch term {
+	case BISECT_TERM_BAD:
+		err = os.WriteFile(bisect.path(BISECT_BAD), []byte(oid), FP)
+	case BISECT_TERM_GOOD:
+		err = bisect.appendTo(BISECT_GOOD, oid)
+	case BISECT_TERM_SKIP:
+		err = bisect.appendTo(BISECT_SKIP, oid)
+	default:
+		return fmt.Errorf("unknown bisect term \"%s\"", term)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err = bisect.appendTo(BISECT_LOG, fmt.Sprintf("# %s: %s", term, description)); err != nil {
+		return err
+	}
+	return bisect.appendTo(BISECT_LOG, fmt.Sprintf("%s%s %s", BISECT_LOG_COMMAND, term, oid))
+}
+
+// Next picks the commit that splits the untested history most evenly and checks it out. Nothing is
+// checked out until there is both a bad and a good commit, or once the first bad commit is found
+func (Bisect) Next() (BisectStep, error) {
+	bad, err := bisect.read(BISECT_BAD)
+	if err != nil {
+		return BisectStep{}, err
+	}
+	good, err := bisect.read(BISECT_GOOD)
+	if err != nil {
+		return BisectStep{}, err
+	}
+	skipped, err := bisect.read(BISECT_SKIP)
+	if err != nil {
+		return BisectStep{}, err
+	}
+	if len(bad) == 0 || len(good) == 0 {
+		return BisectStep{}, nil
+	}
+
+	// The first bad commit is the bad commit or one of its ancestors that is not known to be good
+	candidates := slices.Collect(base.walkCommits(bad, good, false, ORDER_DEFAULT))
+	untested := slices.DeleteFunc(slices.Clone(candidates), func(oid string) bool {
+		return oid == bad[0] || slices.Contains(skipped, oid)
+	})
+
+	if len(untested) == 0 {
+		if len(candidates) == 1 {
+			step := BisectStep{FirstBad: bad[0]}
+			description, err := bisect.describe(bad[0])
+			if err != nil {
+				return step, err
+			}
+			return step, bisect.appendTo(BISECT_LOG, "# first bad commit: "+description)
+		}
+		return BisectStep{Skipped: candidates}, nil
+	}
+
+	graph, err := commitGraph.view()
+	if err != nil {
+		return BisectStep{}, err
+	}
+
+	// Testing a commit settles either its ancestors among the candidates or the rest, so the
+	// best commit is the one for which the smaller of the two groups is largest
+	best, bestScore := "", -1
+	candidateSet := ds.NewSet(candidates)
+	for _, oid := range untested {
+		reached, err := bisect.countReachable(graph, oid, candidateSet)
+		if err != nil {
+			return BisectStep{}, err
+		}
+		if score := min(reached, len(candidates)-reached); score > bestScore {
+			best, bestScore = oid, score
+		}
+	}
+
+	if err = base.Checkout(best, false, CHECKOUT_SAFE); err != nil {
+		return BisectStep{}, err
+	}
+	remaining := len(candidates) / 2
+	return BisectStep{Oid: best, Remaining: remaining, Steps: bits.Len(uint(remaining))}, nil
+}
+
+// countReachable returns how many candidates are oid or its ancestors. The candidates are the ancestors
+// of a bad commit that no good commit reaches, so any path between two of them only goes through
+// candidates and the walk can stop wherever it leaves them
+func (Bisect) countReachable(graph *commitGraphView, oid string, candidates ds.Set[string]) (int, error) {
+	visited := ds.NewSet([]string{oid})
+	queue := []string{oid}
+	for len(queue) > 0 {
+		var current string
+		current, queue = queue[0], queue[1:]
+		entry, err := graph.get(current)
+		if err != nil {
+			return 0, err
+		}
+		for _, parent := range entry.Parents {
+			if candidates.Includes(parent) && !visited.Includes(parent) {
+				visited.Add(parent)
+				queue = append(queue, parent)
+			}
+		}
+	}
+	return visited.Length(), nil
+}
+
+// Reset ends the bisection and checks out what was checked out when it started
+func (Bisect) Reset() error {
+	if !bisect.InProgress() {
+		return nil
+	}
+	start, err := os.ReadFile(bisect.path(BISECT_START))
+	if err != nil {
+		return err
+	}
+	if err = base.Checkout(string(start), false, CHECKOUT_SAFE); err != nil {
+		return err
+	}
+	return bisect.clear()
+}
+
+func (Bisect) clear() error {
+	for _, name := range []string{BISECT_START, BISECT_BAD, BISECT_GOOD, BISECT_SKIP, BISECT_LOG} {
+		if err := os.Remove(bisect.path(name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Log returns the bisect log, which lists every decision made and can be edited and replayed
+func (Bisect) Log() (string, error) {
+	if !bisect.InProgress() {
+		return "", fmt.Errorf("not bisecting")
+	}
+	buf, err := os.ReadFile(bisect.path(BISECT_LOG))
+	return string(buf), err
+}
+
+// Replay starts a new bisection and applies the commands of a bisect log to it
+func (Bisect) Replay(log string) (BisectStep, error) {
+	if err := bisect.Reset(); err != nil {
+		return BisectStep{}, err
+	}
+
+	for _, line := range strings.Split(log, "\n") {
+		command, ok := strings.CutPrefix(strings.TrimSpace(line), BISECT_LOG_COMMAND)
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(command)
+		if len(fields) == 0 {
+			continue
+		}
+
+		var err error
+		switch {
+		case fields[0] == "start":
+			err = bisect.Start()
+		case len(fields) == 2:
+			err = bisect.Mark(fields[0], fields[1])
+		default:
+			err = fmt.Errorf("malformed bisect log line %q", line)
+		}
+		if err != nil {
+			return BisectStep{}, err
+		}
+	}
+	return bisect.Next()
+}
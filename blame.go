@@ -0,0 +1,252 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Blame struct{}
+
+// Namespacing
+var blame Blame
+
+// BlameLine is a line of a file along with the commit that introduced it
+type BlameLine struct {
+	Oid       string
+	OrigLine  int // Line number in the file of the commit that introduced it
+	FinalLine int
+	Content   string
+}
+
+// fileLines returns the lines of the file at path in a commit's tree. Returns false if the tree has no such file
+func (Blame) fileLines(c *CommitObject, path string) ([]string, string, bool, error) {
+	tree, err := base.GetTree(c.TreeOid, "")
+	if err != nil {
+		return nil, "", false, err
+	}
+	oid, ok := tree[path]
+	if !ok {
+		return nil, "", false, nil
+	}
+
+	buf, _, err := data.GetObject(oid)
+	if err != nil {
+		return nil, "", false, err
+	}
+	lines := strings.SplitAfter(string(buf), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines, oid, true, nil
+}
+
+// unchangedLines maps the line numbers of the second blob to the line numbers of the first for
+// every line the diff between them leaves untouched
+func (Blame) unchangedLines(path, fromOid, toOid string, toLength int) (map[int]int, error) {
+	unchanged := make(map[int]int)
+	if fromOid == toOid {
+		for line := 1; line <= toLength; line++ {
+			unchanged[line] = line
+		}
+		return unchanged, nil
+	}
+
+	hunks, err := patch.getHunks(path, fromOid, toOid)
+	if err != nil {
+		return nil, err
+	}
+
+	fromLine, toLine := 1, 1
+	for _, h := range hunks {
+		// A hunk that only adds lines starts after the line it names
+		start := h.FromStart
+		if h.FromCount == 0 {
+			start++
+		}
+		for ; fromLine < start; fromLine, toLine = fromLine+1, toLine+1 {
+			unchanged[toLine] = fromLine
+		}
+
+		for _, line := range h.Lines {
+			switch line[0] {
+			case ' ':
+				unchanged[toLine] = fromLine
+				fromLine, toLine = fromLine+1, toLine+1
+			case '-':
+				fromLine++
+			case '+':
+				toLine++
+			}
+		}
+	}
+	for ; toLine <= toLength; fromLine, toLine = fromLine+1, toLine+1 {
+		unchanged[toLine] = fromLine
+	}
+	return unchanged, nil
+}
+
+// Lines returns the lines of path at rev, each with the commit that last changed it. Lines are
+// handed down from every commit to the first of its parents that has them unchanged, and blamed on
+// the commit once no parent does. Commits are walked in topological order so a commit has received
+// the lines of all of its children before it is looked at
+func (Blame) Lines(rev, path string) ([]BlameLine, map[string]*CommitObject, error) {
+	oid, err := base.GetOid(rev)
+	if err != nil {
+		return nil, nil, err
+	}
+	c, err := base.GetCommit(oid)
+	if err != nil {
+		return nil, nil, err
+	}
+	final, _, ok, err := blame.fileLines(c, path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !ok {
+		return nil, nil, fmt.Errorf("no such path %s in %s", path, rev)
+	}
+
+	result := make([]BlameLine, len(final))
+	commits := map[string]*CommitObject{}
+
+	// Key: commit oid, Value: line number in that commit's file to line number in the final file
+	tracked := map[string]map[int]int{oid: {}}
+	for line := range final {
+		tracked[oid][line+1] = line + 1
+	}
+
+	remaining := len(final)
+	for commitOid := range base.walkCommits([]string{oid}, nil, false, ORDER_TOPO) {
+		if remaining == 0 {
+			break
+		}
+		lines := tracked[commitOid]
+		if len(lines) == 0 {
+			continue
+		}
+		delete(tracked, commitOid)
+
+		c, err := base.GetCommit(commitOid)
+		if err != nil {
+			return nil, nil, err
+		}
+		content, blobOid, _, err := blame.fileLines(c, path)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for _, parentOid := range c.ParentOids {
+			parent, err := base.GetCommit(parentOid)
+			if err != nil {
+				return nil, nil, err
+			}
+			_, parentBlobOid, ok, err := blame.fileLines(parent, path)
+			if err != nil {
+				return nil, nil, err
+			}
+			if !ok {
+				continue
+			}
+
+			unchanged, err := blame.unchangedLines(path, parentBlobOid, blobOid, len(content))
+			if err != nil {
+				return nil, nil, err
+			}
+			for line, finalLine := range lines {
+				if parentLine, ok := unchanged[line]; ok {
+					if tracked[parentOid] == nil {
+						tracked[parentOid] = map[int]int{}
+					}
+					tracked[parentOid][parentLine] = finalLine
+					delete(lines, line)
+				}
+			}
+		}
+
+		for line, finalLine := range lines {
+			result[finalLine-1] = BlameLine{commitOid, line, finalLine, final[finalLine-1]}
+			commits[commitOid] = c
+			remaining--
+		}
+	}
+	return result, commits, nil
+}
+
+// ParseRange parses the -L argument "<start>,<end>" or "<start>,+<count>" against a file of length
+// lines. Either side may be left out to mean the first or last line
+func (Blame) ParseRange(spec string, length int) (int, int, error) {
+	startStr, endStr, _ := strings.Cut(spec, ",")
+	start, end := 1, length
+
+	var err error
+	if startStr != "" {
+		if start, err = strconv.Atoi(startStr); err != nil {
+			return 0, 0, fmt.Errorf("invalid line range \"%s\"", spec)
+		}
+	}
+	if count, ok := strings.CutPrefix(endStr, "+"); ok {
+		n, err := strconv.Atoi(count)
+		if err != nil || n < 1 {
+			return 0, 0, fmt.Errorf("invalid line range \"%s\"", spec)
+		}
+		end = start + n - 1
+	} else if endStr != "" {
+		if end, err = strconv.Atoi(endStr); err != nil {
+			return 0, 0, fmt.Errorf("invalid line range \"%s\"", spec)
+		}
+	}
+
+	if start < 1 || start > length || end < start {
+		return 0, 0, fmt.Errorf("line range \"%s\" is outside the file's %d lines", spec, length)
+	}
+	return start, min(end, length), nil
+}
+
+// Print writes the blamed lines in the range [start, end], counted from 1. The porcelain format
+// gives the details of each commit the first time it appears, as git does for editors to parse
+func (Blame) Print(lines []BlameLine, commits map[string]*CommitObject, path string, start, end int, porcelain bool) {
+	seen := map[string]bool{}
+	for i := start - 1; i < end; i++ {
+		line := lines[i]
+		c := commits[line.Oid]
+		name, email, _ := strings.Cut(c.Author, " <")
+		email = strings.TrimSuffix(email, ">")
+		date := cmp.Or(c.AuthorTime, c.Timestamp)
+		content := strings.TrimSuffix(line.Content, "\n")
+
+		if !porcelain {
+			fmt.Printf(
+				"%s (%s %s %*d) %s\n",
+				line.Oid[:min(len(line.Oid), ABBREV_LENGTH)], name, date.Format(time.DateTime+" -0700"),
+				len(fmt.Sprint(end)), line.FinalLine, content,
+			)
+			continue
+		}
+
+		// Consecutive lines of the same commit form a group, whose size is given on its first line
+		header := fmt.Sprintf("%s %d %d", line.Oid, line.OrigLine, line.FinalLine)
+		if i == start-1 || lines[i-1].Oid != line.Oid || lines[i-1].OrigLine != line.OrigLine-1 {
+			group := 1
+			for i+group < end && lines[i+group].Oid == line.Oid && lines[i+group].OrigLine == line.OrigLine+group {
+				group++
+			}
+			header += fmt.Sprintf(" %d", group)
+		}
+		fmt.Println(header)
+
+		if !seen[line.Oid] {
+			seen[line.Oid] = true
+			subject, _, _ := strings.Cut(c.Message, "\n")
+			fmt.Printf("author %s\n", name)
+			fmt.Printf("author-mail <%s>\n", email)
+			fmt.Printf("author-time %d\n", date.Unix())
+			fmt.Printf("author-tz %s\n", date.Format("-0700"))
+			fmt.Printf("summary %s\n", subject)
+			fmt.Printf("filename %s\n", path)
+		}
+		fmt.Printf("\t%s\n", content)
+	}
+}
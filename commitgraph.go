@@ -0,0 +1,324 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	ds "local/gogit/data-structures"
+)
+
+type CommitGraph struct{}
+
+// Namespacing
+var commitGraph CommitGraph
+
+// File in the gogit directory caching the parents, tree, date and generation of commits, one commit
+// per line
+const COMMIT_GRAPH_FILE = "commit-graph"
+
+// CommitGraphEntry is what the commit graph knows about a commit. The generation of a root commit
+// is 1 and every other commit's is one more than the highest generation among its parents, so a
+// commit can never be an ancestor of one with a lower or equal generation
+type CommitGraphEntry struct {
+	Tree       string   `json:"tree"`
+	Parents    []string `json:"parents"`
+	Time       int64    `json:"time"`
+	Generation int      `json:"generation"`
+}
+
+// A line of the commit graph file
+type commitGraphLine struct {
+	Oid string `json:"oid"`
+	CommitGraphEntry
+}
+
+type commitGraphCacheEntry struct {
+	modTime time.Time
+	size    int64
+	entries map[string]CommitGraphEntry
+}
+
+// The commit graph file is only re-parsed when it changes on disk
+var commitGraphCache = map[string]commitGraphCacheEntry{}
+
+func (CommitGraph) path() string {
+	return filepath.Join(GOGIT_ROOT, COMMIT_GRAPH_FILE)
+}
+
+// read returns the entries of the commit graph file. The map is shared with the cache and only Add
+// modifies it
+func (CommitGraph) read() (map[string]CommitGraphEntry, error) {
+	fp := commitGraph.path()
+	info, err := os.Stat(fp)
+	if err != nil {
+		delete(commitGraphCache, fp)
+		if os.IsNotExist(err) {
+			return map[string]CommitGraphEntry{}, nil
+		}
+		return nil, err
+	}
+
+	if cached, ok := commitGraphCache[fp]; ok && cached.modTime.Equal(info.ModTime()) && cached.size == info.Size() {
+		return cached.entries, nil
+	}
+
+	f, err := os.Open(fp)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries := make(map[string]CommitGraphEntry)
+	decoder := json.NewDecoder(f)
+	for decoder.More() {
+		var line commitGraphLine
+		if err = decoder.Decode(&line); err != nil {
+			return nil, fmt.Errorf("malformed commit graph: %w", err)
+		}
+		entries[line.Oid] = line.CommitGraphEntry
+	}
+	commitGraphCache[fp] = commitGraphCacheEntry{info.ModTime(), info.Size(), entries}
+	return entries, nil
+}
+
+// write replaces the commit graph file with entries
+func (CommitGraph) write(entries map[string]CommitGraphEntry) error {
+	lock, err := data.lockRef(COMMIT_GRAPH_FILE)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		lock.Close()
+		os.Remove(commitGraph.path() + LOCK_SUFFIX)
+	}()
+
+	for _, oid := range slices.Sorted(maps.Keys(entries)) {
+		buf, err := json.Marshal(commitGraphLine{oid, entries[oid]})
+		if err != nil {
+			return err
+		}
+		if _, err = lock.Write(append(buf, '\n')); err != nil {
+			return err
+		}
+	}
+	if err = lock.Close(); err != nil {
+		return err
+	}
+	delete(commitGraphCache, commitGraph.path())
+	return os.Rename(commitGraph.path()+LOCK_SUFFIX, commitGraph.path())
+}
+
+// commitGraphView answers lookups from the commit graph file and parses the commits missing from it.
+// Parsed commits are only remembered for the lifetime of the view
+type commitGraphView struct {
+	entries  map[string]CommitGraphEntry
+	computed map[string]CommitGraphEntry
+}
+
+func (CommitGraph) view() (*commitGraphView, error) {
+	entries, err := commitGraph.read()
+	if err != nil {
+		return nil, err
+	}
+	return &commitGraphView{entries, map[string]CommitGraphEntry{}}, nil
+}
+
+func (v *commitGraphView) get(oid string) (CommitGraphEntry, error) {
+	if entry, ok := v.entries[oid]; ok {
+		return entry, nil
+	}
+	if entry, ok := v.computed[oid]; ok {
+		return entry, nil
+	}
+
+	c, err := base.GetCommit(oid)
+	if err != nil {
+		return CommitGraphEntry{}, err
+	}
+	entry, err := v.entryFor(c)
+	if err != nil {
+		return CommitGraphEntry{}, err
+	}
+	v.computed[oid] = entry
+	return entry, nil
+}
+
+// entryFor builds the entry of a commit, looking up its parents for its generation
+func (v *commitGraphView) entryFor(c *CommitObject) (CommitGraphEntry, error) {
+	entry := CommitGraphEntry{c.TreeOid, c.ParentOids, c.Timestamp.Unix(), 1}
+	for _, parentOid := range c.ParentOids {
+		parent, err := v.get(parentOid)
+		if err != nil {
+			return CommitGraphEntry{}, err
+		}
+		entry.Generation = max(entry.Generation, parent.Generation+1)
+	}
+	return entry, nil
+}
+
+// Add appends a newly written commit to the commit graph file, leaving the rest of the file to gc.
+// Commits missing from the file are parsed when they are needed, so an append lost to a concurrent
+// rewrite only costs time
+func (CommitGraph) Add(oid string, c *CommitObject) error {
+	v, err := commitGraph.view()
+	if err != nil {
+		return err
+	}
+	if _, ok := v.entries[oid]; ok {
+		return nil
+	}
+
+	entry, err := v.entryFor(c)
+	if err != nil {
+		return err
+	}
+	buf, err := json.Marshal(commitGraphLine{oid, entry})
+	if err != nil {
+		return err
+	}
+
+	fp := commitGraph.path()
+	f, err := os.OpenFile(fp, os.O_APPEND|os.O_CREATE|os.O_WRONLY, FP)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(append(buf, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+
+	// The cache was read before the append, so it stays valid with the new entry added
+	if cached, ok := commitGraphCache[fp]; ok {
+		info, err := os.Stat(fp)
+		if err != nil {
+			return err
+		}
+		cached.entries[oid] = entry
+		commitGraphCache[fp] = commitGraphCacheEntry{info.ModTime(), info.Size(), cached.entries}
+	}
+	return nil
+}
+
+// Write replaces the commit graph file with the history of oids, dropping every other commit
+func (CommitGraph) Write(oids []string) error {
+	v, err := commitGraph.view()
+	if err != nil {
+		return err
+	}
+
+	ancestors, err := v.ancestors(oids, 0)
+	if err != nil {
+		return err
+	}
+	entries := make(map[string]CommitGraphEntry)
+	for _, oid := range ancestors {
+		if entries[oid], err = v.get(oid); err != nil {
+			return err
+		}
+	}
+	return commitGraph.write(entries)
+}
+
+// ancestors returns oids and every commit reachable from them, leaving out commits with a generation
+// below minGeneration along with their history
+func (v *commitGraphView) ancestors(oids []string, minGeneration int) ([]string, error) {
+	visited := ds.NewSet([]string{})
+	var found []string
+	for len(oids) > 0 {
+		var oid string
+		oid, oids = oids[0], oids[1:]
+		if oid == "" || visited.Includes(oid) {
+			continue
+		}
+		visited.Add(oid)
+
+		entry, err := v.get(oid)
+		if err != nil {
+			return nil, err
+		}
+		if entry.Generation < minGeneration {
+			continue
+		}
+		found = append(found, oid)
+		oids = append(oids, entry.Parents...)
+	}
+	return found, nil
+}
+
+// IsAncestor returns true if ancestor is reachable from descendant. Commits with a generation lower
+// than the ancestor's cannot lead to it, so the walk stops at them
+func (CommitGraph) IsAncestor(ancestor, descendant string) (bool, error) {
+	v, err := commitGraph.view()
+	if err != nil {
+		return false, err
+	}
+	target, err := v.get(ancestor)
+	if err != nil {
+		return false, err
+	}
+	ancestors, err := v.ancestors([]string{descendant}, target.Generation)
+	return slices.Contains(ancestors, ancestor), err
+}
+
+// MergeBase returns the best common ancestor of two commits. Commits are visited highest generation
+// first, so every child of a commit is visited before it and has already passed on which of the two
+// commits it can be reached from. The first commit reachable from both cannot be an ancestor of
+// any other common ancestor, and the walk ends there
+func (CommitGraph) MergeBase(oid1, oid2 string) (string, bool, error) {
+	v, err := commitGraph.view()
+	if err != nil {
+		return "", false, err
+	}
+
+	const (
+		FROM_FIRST = 1 << iota
+		FROM_SECOND
+	)
+	reachedFrom := make(map[string]int)
+	generations := make(map[string]int)
+	queue := ds.NewHeap(func(a, b string) bool { return generations[a] > generations[b] })
+
+	push := func(oid string, from int) error {
+		if _, queued := reachedFrom[oid]; !queued {
+			entry, err := v.get(oid)
+			if err != nil {
+				return err
+			}
+			generations[oid] = entry.Generation
+			queue.Push(oid)
+		}
+		reachedFrom[oid] |= from
+		return nil
+	}
+
+	if err = push(oid1, FROM_FIRST); err != nil {
+		return "", false, err
+	}
+	if err = push(oid2, FROM_SECOND); err != nil {
+		return "", false, err
+	}
+	for queue.Length() > 0 {
+		oid := queue.Pop()
+		if reachedFrom[oid] == FROM_FIRST|FROM_SECOND {
+			return oid, true, nil
+		}
+		entry, err := v.get(oid)
+		if err != nil {
+			return "", false, err
+		}
+		for _, parent := range entry.Parents {
+			if err = push(parent, reachedFrom[oid]); err != nil {
+				return "", false, err
+			}
+		}
+	}
+	return "", false, nil
+}
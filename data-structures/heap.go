@@ -0,0 +1,71 @@
+package data_structures
+
+// Heap is a binary heap ordered by less. Pop returns the item for which less is true against every
+// other item, so a less of a > b makes a max-heap
+type Heap[T any] struct {
+	items []T
+	less  func(a, b T) bool
+}
+
+func NewHeap[T any](less func(a, b T) bool, items ...T) Heap[T] {
+	h := Heap[T]{less: less}
+	h.Push(items...)
+	return h
+}
+
+func (h *Heap[T]) Push(items ...T) {
+	for _, item := range items {
+		h.items = append(h.items, item)
+		h.up(len(h.items) - 1)
+	}
+}
+
+func (h *Heap[T]) Length() int {
+	return len(h.items)
+}
+
+func (h *Heap[T]) Peek() T {
+	if h.Length() == 0 {
+		panic("heap: Peek() called on empty heap")
+	}
+	return h.items[0]
+}
+
+func (h *Heap[T]) Pop() T {
+	if h.Length() == 0 {
+		panic("heap: Pop() called on empty heap")
+	}
+	top := h.items[0]
+	last := len(h.items) - 1
+	h.items[0] = h.items[last]
+	h.items = h.items[:last]
+	h.down(0)
+	return top
+}
+
+func (h *Heap[T]) up(i int) {
+	for i > 0 {
+		parent := (i - 1) / 2
+		if !h.less(h.items[i], h.items[parent]) {
+			return
+		}
+		h.items[i], h.items[parent] = h.items[parent], h.items[i]
+		i = parent
+	}
+}
+
+func (h *Heap[T]) down(i int) {
+	for {
+		smallest := i
+		for _, child := range []int{2*i + 1, 2*i + 2} {
+			if child < len(h.items) && h.less(h.items[child], h.items[smallest]) {
+				smallest = child
+			}
+		}
+		if smallest == i {
+			return
+		}
+		h.items[i], h.items[smallest] = h.items[smallest], h.items[i]
+		i = smallest
+	}
+}
@@ -0,0 +1,35 @@
+package data_structures
+
+import (
+	"testing"
+)
+
+func TestHeap_Pop(t *testing.T) {
+	h := NewHeap(func(a, b int) bool { return a < b }, 5, 3, 8, 1, 9, 2)
+	expect(t, h.Length(), 6)
+	expect(t, h.Peek(), 1)
+
+	var popped []int
+	for h.Length() > 0 {
+		popped = append(popped, h.Pop())
+	}
+	for i, n := range []int{1, 2, 3, 5, 8, 9} {
+		expect(t, popped[i], n)
+	}
+
+	expectPanic(t, func() { h.Pop() })
+}
+
+func TestHeap_Push(t *testing.T) {
+	// A greater-than comparison makes a max-heap
+	h := NewHeap(func(a, b string) bool { return a > b })
+	h.Push("b", "a")
+	h.Push("c")
+	expect(t, h.Length(), 3)
+	expect(t, h.Pop(), "c")
+
+	h.Push("d")
+	expect(t, h.Pop(), "d")
+	expect(t, h.Pop(), "b")
+	expect(t, h.Length(), 1)
+}
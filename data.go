@@ -1,7 +1,9 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"cmp"
 	"compress/zlib"
 	"crypto/sha1"
 	"encoding/hex"
@@ -11,16 +13,22 @@ import (
 	"io/fs"
 	"iter"
 	"maps"
+	"math/rand/v2"
 	"os"
+	"os/user"
 	"path/filepath"
 	"reflect"
 	"regexp"
+	"slices"
 	"strings"
 )
 
 // FP: File permission
 const FP = 0777
 
+// Directory in the gogit directory holding files that are still being written
+const TMP_DIR = "tmp"
+
 // Var so can be overriden in tests
 var COMPRESS_OBJECTS = true
 
@@ -40,45 +48,40 @@ func (Data) isValidSHA1(digest string) bool {
 	return match
 }
 
+// isIgnored returns true if path is excluded by the ignore rules
 func (Data) isIgnored(path string) bool {
-	data, _ := os.ReadFile(filepath.Join(".", ".gogitignore"))
-	ignorable := append(strings.Split(string(data), "\n"), GOGIT_DIR)
-	for _, fp := range ignorable {
-		if fp != "" && strings.Contains(path, fp) {
-			return true
-		}
-	}
-	return false
+	rule := ignore.Match(path)
+	return rule != nil && !rule.negate
 }
 
-func (Data) emptyCurrentDir() error {
-	return filepath.WalkDir(".", func(path string, di fs.DirEntry, err error) error {
-		if data.isIgnored(path) || strings.Contains(path, GOGIT_DIR) {
-			return nil
-		}
-
-		if e := os.RemoveAll(path); e != nil {
-			if _, ok := e.(*os.PathError); ok {
-				return nil
-			}
-			return e
+// removeEmptyDirs removes dir and its parents for as long as they are empty
+func (Data) removeEmptyDirs(dir string) {
+	for dir != "." && dir != "" {
+		if err := os.Remove(dir); err != nil {
+			return
 		}
-		// Pass
-		return nil
-	})
+		dir = filepath.Dir(dir)
+	}
 }
 
 // Iterates over all refs and returns a ref name, and pointer to a RefValue object
 func (Data) iterRefs(prefix string, deref bool) (iter.Seq2[string, *RefValue], error) {
 	refNames := []string{HEAD, MERGE_HEAD}
 	refDir := filepath.Join("refs", prefix)
+	seen := make(map[string]bool)
 	err := filepath.WalkDir(filepath.Join(GOGIT_ROOT, refDir), func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
+			// All refs under the prefix may have been packed
+			if os.IsNotExist(err) {
+				return fs.SkipAll
+			}
 			return err
 		}
-		if !d.IsDir() {
+		// Lock files belong to updates in progress
+		if !d.IsDir() && !strings.HasSuffix(path, LOCK_SUFFIX) {
 			relP, err := filepath.Rel(GOGIT_ROOT, path)
 			refNames = append(refNames, relP)
+			seen[relP] = true
 			return err
 		}
 		return nil
@@ -88,6 +91,17 @@ func (Data) iterRefs(prefix string, deref bool) (iter.Seq2[string, *RefValue], e
 		return nil, err
 	}
 
+	// Loose refs take precedence over packed ones with the same name
+	packed, err := data.readPackedRefs()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range slices.Sorted(maps.Keys(packed)) {
+		if !seen[name] {
+			refNames = append(refNames, name)
+		}
+	}
+
 	return func(yield func(string, *RefValue) bool) {
 		for _, refName := range refNames {
 			if !strings.HasPrefix(refName, refDir) {
@@ -111,6 +125,7 @@ func (Data) Init() error {
 
 	dirs := []string{
 		filepath.Join(GOGIT_ROOT, "objects"),
+		filepath.Join(GOGIT_ROOT, TMP_DIR),
 		filepath.Join(GOGIT_ROOT, "refs", "heads"),
 		filepath.Join(GOGIT_ROOT, "refs", "tags"),
 	}
@@ -170,93 +185,237 @@ func (Data) WithIndex(
 }
 
 // HashObject hashes a byte array and return the resulting MD5 hash ID
-func (Data) HashObject(data []byte, _type string) (string, error) {
+func (Data) HashObject(buf []byte, _type string) (string, error) {
+	return data.HashObjectStream(bytes.NewReader(buf), _type)
+}
+
+// HashObjectStream reads an object's content from r, hashing and compressing it on the fly
+// into a temporary file which is moved into the object store once the oid is known. Temporary
+// files are kept out of the objects directory, where gc would remove them as unreachable
+func (Data) HashObjectStream(r io.Reader, _type string) (string, error) {
+	tmpDir := filepath.Join(GOGIT_ROOT, TMP_DIR)
+	if err := os.MkdirAll(tmpDir, FP); err != nil {
+		return "", err
+	}
+	f, err := data.createTemp(tmpDir, "obj-")
+	if err != nil {
+		return "", err
+	}
+	// Once the object has been renamed this is a no-op
+	defer os.Remove(f.Name())
+
 	hasher := sha1.New()
+	var w io.Writer = f
+	var zw *zlib.Writer
+	if COMPRESS_OBJECTS {
+		zw = zlib.NewWriter(f)
+		w = zw
+	}
+	mw := io.MultiWriter(hasher, w)
+
 	// Type separated from data by NULL byte
-	buf := []byte(fmt.Sprintf("%s\x00%s", _type, data))
+	_, err = io.WriteString(mw, _type+"\x00")
+	if err == nil {
+		_, err = io.Copy(mw, r)
+	}
+	if zw != nil {
+		if closeErr := zw.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
 
-	hasher.Write(buf)
 	oid := hex.EncodeToString(hasher.Sum(nil))
-
-	fp := filepath.Join(GOGIT_ROOT, "objects", oid)
-	// Is this more efficient than just always writing the file?
-	if _, err := os.Stat(fp); os.IsExist(err) {
+	if data.ObjectExists(oid) {
 		return oid, nil
 	}
+	return oid, os.Rename(f.Name(), filepath.Join(GOGIT_ROOT, "objects", oid))
+}
 
-	// Zlib compress buffer
-	var b bytes.Buffer
-	if COMPRESS_OBJECTS {
-		w := zlib.NewWriter(&b)
-		_, _ = w.Write(buf)
-		w.Close()
-	} else {
-		b = *bytes.NewBuffer(buf)
+// createTemp creates a new file in dir named prefix followed by a random number. Unlike os.CreateTemp
+// it opens the file with FP, so the umask decides its mode as it does for every other file
+func (Data) createTemp(dir, prefix string) (*os.File, error) {
+	for {
+		name := filepath.Join(dir, fmt.Sprintf("%s%d", prefix, rand.Uint64()))
+		f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, FP)
+		if !os.IsExist(err) {
+			return f, err
+		}
 	}
+}
 
-	if err := os.WriteFile(fp, b.Bytes(), FP); err != nil {
+// hashFile streams the file at path into the object store as a blob
+func (Data) hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
 		return "", err
 	}
-	return oid, nil
+	defer f.Close()
+	return data.HashObjectStream(f, BLOB)
 }
 
-// GetObject takes an oid and returns the object content and type
-func (Data) GetObject(oid string) ([]byte, string, error) {
-	data, err := os.ReadFile(filepath.Join(GOGIT_ROOT, "objects", oid))
+// objectReader reads the content of an object, closing every underlying reader on Close
+type objectReader struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (r objectReader) Close() error {
+	var err error
+	for i := len(r.closers) - 1; i >= 0; i-- {
+		if e := r.closers[i].Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// OpenObject takes an oid and returns a reader over the object content and the object type.
+// The content is decompressed as it is read, so the caller must close the reader
+func (Data) OpenObject(oid string) (io.ReadCloser, string, error) {
+	f, err := os.Open(filepath.Join(GOGIT_ROOT, "objects", oid))
 	if err != nil {
-		return []byte{}, "", err
+		return nil, "", err
 	}
 
-	// Zlib uncompress buffer
-	var b bytes.Buffer
+	var r io.Reader = f
+	closers := []io.Closer{f}
 	if COMPRESS_OBJECTS {
-		compressedBuf := bytes.NewBuffer(data)
-		r, err := zlib.NewReader(compressedBuf)
+		zr, err := zlib.NewReader(f)
 		if err != nil {
-			return []byte{}, "", err
+			f.Close()
+			return nil, "", err
 		}
-		_, _ = io.Copy(&b, r)
-		r.Close()
-	} else {
-		b = *bytes.NewBuffer(data)
+		r = zr
+		closers = append(closers, zr)
 	}
 
-	buf := b.Bytes()
-	typeIdx := bytes.IndexByte(buf, 0)
-	return buf[typeIdx+1:], string(buf[:typeIdx]), nil
+	br := bufio.NewReader(r)
+	_type, err := br.ReadString(0)
+	if err != nil {
+		objectReader{closers: closers}.Close()
+		return nil, "", fmt.Errorf("malformed object %s: %w", oid, err)
+	}
+	return objectReader{br, closers}, strings.TrimSuffix(_type, "\x00"), nil
 }
 
-func (Data) DeleteObject(oid string) error {
-	return os.Remove(filepath.Join(GOGIT_DIR, "objects", oid))
+// GetObject takes an oid and returns the object content and type
+func (Data) GetObject(oid string) ([]byte, string, error) {
+	r, t, err := data.OpenObject(oid)
+	if err != nil {
+		return []byte{}, "", err
+	}
+	defer r.Close()
+
+	buf, err := io.ReadAll(r)
+	if err != nil {
+		return []byte{}, "", err
+	}
+	return buf, t, nil
 }
 
-func (Data) DeleteRef(name string, deref bool) error {
-	name, _, err := data.getRefInternal(name, deref)
+// copyObjectToFile streams the content of a blob into the file at path
+func (Data) copyObjectToFile(oid, path string) error {
+	r, t, err := data.OpenObject(oid)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	if t != BLOB {
+		return ObjectTypeError{received: t, expected: BLOB}
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, FP)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// copyFile streams the file at src into dst without loading it into memory
+func (Data) copyFile(src, dst string) error {
+	in, err := os.Open(src)
 	if err != nil {
 		return err
 	}
+	defer in.Close()
 
-	if err = os.Remove(filepath.Join(GOGIT_ROOT, name)); err != nil && !os.IsNotExist(err) {
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, FP)
+	if err != nil {
 		return err
 	}
-	return nil
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func (Data) DeleteObject(oid string) error {
+	return os.Remove(filepath.Join(GOGIT_DIR, "objects", oid))
+}
+
+// DeleteRef removes a ref along with its log
+func (Data) DeleteRef(name string, deref bool) error {
+	t := data.NewRefTransaction()
+	t.Delete(name, deref, "")
+	return t.Commit()
 }
 
 // UpdateRef takes a ref name, RefValue object, and dereference boolean. If deref is true, we drill down
-// symbolic refs until we reach an oid
-func (Data) UpdateRef(name string, ref *RefValue, deref bool) error {
-	name, _, err := data.getRefInternal(name, deref)
+// symbolic refs until we reach an oid. The update is recorded in the log of the ref with reason as its
+// message, and also in the log of name when it was updated through a symbolic ref like HEAD
+func (Data) UpdateRef(name string, ref *RefValue, deref bool, reason string) error {
+	t := data.NewRefTransaction()
+	t.Update(name, ref, deref, "", reason)
+	return t.Commit()
+}
+
+// writeRef writes the value of a ref without logging it
+func (Data) writeRef(name string, ref *RefValue) error {
+	lock, err := data.lockRef(name)
 	if err != nil {
 		return err
 	}
+	defer os.Remove(data.refPath(name) + LOCK_SUFFIX)
+
+	if _, err = lock.WriteString(data.formatRef(ref)); err != nil {
+		lock.Close()
+		return err
+	}
+	if err = lock.Close(); err != nil {
+		return err
+	}
+	return os.Rename(data.refPath(name)+LOCK_SUFFIX, data.refPath(name))
+}
 
-	refValue := ref.Value
-	if ref.Symbolic {
-		refValue = fmt.Sprintf("ref: %s", refValue)
+// Identity returns the name and email recorded with ref updates. GOGIT_AUTHOR_NAME and GOGIT_AUTHOR_EMAIL
+// take precedence over the current user's login name and host
+func (Data) Identity() (string, string) {
+	name, email := os.Getenv("GOGIT_AUTHOR_NAME"), os.Getenv("GOGIT_AUTHOR_EMAIL")
+	if name != "" && email != "" {
+		return name, email
 	}
 
-	refPath := filepath.Join(GOGIT_ROOT, filepath.Dir(name))
-	return os.WriteFile(filepath.Join(refPath, filepath.Base(name)), []byte(refValue), FP)
+	login := "unknown"
+	if u, err := user.Current(); err == nil {
+		login = u.Username
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = "localhost"
+	}
+	return cmp.Or(name, login), cmp.Or(email, fmt.Sprintf("%s@%s", login, host))
 }
 
 func (Data) GetRef(name string, deref bool) (*RefValue, error) {
@@ -275,6 +434,15 @@ func (Data) getRefInternal(name string, deref bool) (string, *RefValue, error) {
 		return "", nil, err
 	}
 
+	// Refs without a loose file may have been packed
+	if os.IsNotExist(err) && strings.HasPrefix(name, "refs/") {
+		packed, err := data.readPackedRefs()
+		if err != nil {
+			return "", nil, err
+		}
+		return name, &RefValue{false, packed[name]}, nil
+	}
+
 	value := string(buf)
 	refIdx := strings.Index(value, "ref: ")
 	symbolic := refIdx > -1
@@ -301,14 +469,15 @@ func (Data) writeTempBlob(blobOid string, store *[]string) error {
 	defer f.Close()
 
 	if blobOid != "" {
-		blob, t, err := data.GetObject(blobOid)
+		r, t, err := data.OpenObject(blobOid)
 		if err != nil {
 			return err
 		}
+		defer r.Close()
 		if t != BLOB {
 			return ObjectTypeError{received: t, expected: BLOB}
 		}
-		if _, err = f.Write(blob); err != nil {
+		if _, err = io.Copy(f, r); err != nil {
 			return err
 		}
 	}
@@ -331,17 +500,9 @@ func (Data) fetchRemoteObject(oid, remotePath string) error {
 		return nil
 	}
 
-	buf, err := os.ReadFile(filepath.Join(remotePath, "objects", oid))
-	if err != nil {
-		return err
-	}
-	return os.WriteFile(localObjectPath, buf, FP)
+	return data.copyFile(filepath.Join(remotePath, "objects", oid), localObjectPath)
 }
 
 func (Data) pushRemoteObject(oid, remotePath string) error {
-	buf, err := os.ReadFile(filepath.Join(GOGIT_ROOT, "objects", oid))
-	if err != nil {
-		return err
-	}
-	return os.WriteFile(filepath.Join(remotePath, "objects", oid), buf, FP)
+	return data.copyFile(filepath.Join(GOGIT_ROOT, "objects", oid), filepath.Join(remotePath, "objects", oid))
 }
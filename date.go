@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Absolute date formats accepted wherever a date is expected
+var dateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	time.RFC1123Z,
+}
+
+// Matches relative dates like "2.weeks.ago" or "3 days ago"
+var relativeDateRegex = regexp.MustCompile(`^(\d+)[. ](second|minute|hour|day|week|month|year)s?[. ]ago$`)
+
+// parseDate understands absolute dates, unix timestamps written as "@<seconds>", and the relative
+// forms "now", "today", "yesterday", "never" and "<n>.<unit>.ago". Relative dates are taken from now
+func parseDate(s string, now time.Time) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	switch strings.ToLower(s) {
+	case "now", "all":
+		return now, nil
+	case "never":
+		return time.Time{}, nil
+	case "today":
+		y, m, d := now.Date()
+		return time.Date(y, m, d, 0, 0, 0, 0, now.Location()), nil
+	case "yesterday":
+		return now.AddDate(0, 0, -1), nil
+	}
+
+	if unix, ok := strings.CutPrefix(s, "@"); ok {
+		seconds, err := strconv.ParseInt(unix, 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid date \"%s\"", s)
+		}
+		return time.Unix(seconds, 0), nil
+	}
+
+	if match := relativeDateRegex.FindStringSubmatch(strings.ToLower(s)); match != nil {
+		n, _ := strconv.Atoi(match[1])
+		switch match[2] {
+		case "second":
+			return now.Add(-time.Duration(n) * time.Second), nil
+		case "minute":
+			return now.Add(-time.Duration(n) * time.Minute), nil
+		case "hour":
+			return now.Add(-time.Duration(n) * time.Hour), nil
+		case "day":
+			return now.AddDate(0, 0, -n), nil
+		case "week":
+			return now.AddDate(0, 0, -7*n), nil
+		case "month":
+			return now.AddDate(0, -n, 0), nil
+		case "year":
+			return now.AddDate(-n, 0, 0), nil
+		}
+	}
+
+	for _, layout := range dateLayouts {
+		if t, err := time.ParseInLocation(layout, s, now.Location()); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date \"%s\"", s)
+}
+
+// relativeDate describes how long before now t was, like "3 days ago"
+func relativeDate(t, now time.Time) string {
+	d := now.Sub(t)
+	units := []struct {
+		name string
+		size time.Duration
+	}{
+		{"year", 365 * 24 * time.Hour},
+		{"month", 30 * 24 * time.Hour},
+		{"week", 7 * 24 * time.Hour},
+		{"day", 24 * time.Hour},
+		{"hour", time.Hour},
+		{"minute", time.Minute},
+	}
+	for _, unit := range units {
+		if n := int(d / unit.size); n > 0 {
+			if n == 1 {
+				return fmt.Sprintf("1 %s ago", unit.name)
+			}
+			return fmt.Sprintf("%d %ss ago", n, unit.name)
+		}
+	}
+	return fmt.Sprintf("%d seconds ago", max(int(d/time.Second), 0))
+}
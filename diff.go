@@ -1,8 +1,10 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
 	"iter"
+	"maps"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -25,17 +27,74 @@ const (
 	MERGE
 )
 
+// Actions of the files that differ between two trees
+const (
+	ACTION_NEW      = "new file"
+	ACTION_DELETED  = "deleted"
+	ACTION_MODIFIED = "modified"
+	ACTION_RENAMED  = "renamed"
+	ACTION_COPIED   = "copied"
+)
+
+// Oid of the empty blob
+const EMPTY_BLOB_OID = "48ede76ef68a65b7292840b4ad4d1f111359d82a"
+
+// Default similarity, as a percentage, above which a deleted and an added file are taken to be a rename
+const RENAME_THRESHOLD = 50
+
+// RenameOptions selects how changed files are paired up as renames and copies
+type RenameOptions struct {
+	Threshold int  // Minimum similarity as a percentage, 0 turns detection off
+	Copies    bool // Look for the sources of added files among every file, not just the deleted ones
+}
+
+// FileChange is a file that differs between two trees. Renamed and copied files come from FromPath
+type FileChange struct {
+	Path       string
+	Action     string
+	FromPath   string
+	FromOid    string
+	ToOid      string
+	Similarity int
+}
+
+// renameHeader returns the lines introducing the diff of a renamed or copied file
+func (c FileChange) renameHeader() string {
+	verb := "rename"
+	if c.Action == ACTION_COPIED {
+		verb = "copy"
+	}
+	return fmt.Sprintf("similarity index %d%%\n%s from %s\n%s to %s\n", c.Similarity, verb, c.FromPath, verb, c.Path)
+}
+
+func (c FileChange) String() string {
+	if c.Action == ACTION_RENAMED || c.Action == ACTION_COPIED {
+		return fmt.Sprintf("%s: %s -> %s", c.Action, c.FromPath, c.Path)
+	}
+	return fmt.Sprintf("%s: %s", c.Action, c.Path)
+}
+
+// Values of --color
+const (
+	COLOR_AUTO   = "auto"
+	COLOR_ALWAYS = "always"
+	COLOR_NEVER  = "never"
+)
+
+// Var so it can be set by --color
+var COLOR_OUTPUT = COLOR_AUTO
+
 const COLORFLUSH = "\033[0m"
 const RED = "\033[31m"
 const GREEN = "\033[32m"
 
-func (Diff) getDiffCmdAndArgs(action int, path string, files []string) (string, []string) {
+func (Diff) getDiffCmdAndArgs(action int, paths []string, files []string) (string, []string) {
 	switch action {
 	case DIFF:
 		cmd := "diff"
 		args := []string{"--unified", "--show-c-function"}
 		for i, file := range files {
-			args = append(args, "--label", fmt.Sprintf("%s/%s", string(rune(97+i)), path), file)
+			args = append(args, "--label", fmt.Sprintf("%s/%s", string(rune(97+i)), paths[i]), file)
 		}
 		return cmd, args
 	case MERGE:
@@ -87,59 +146,287 @@ func (Diff) compareTrees(trees ...Tree) iter.Seq2[string, []string] {
 	}
 }
 
-// iterChangedFiles returns an iterator of [filepath, action]
-func (Diff) iterChangedFiles(fromTree, toTree Tree) iter.Seq2[string, string] {
-	return func(yield func(string, string) bool) {
-		for path, oids := range diff.compareTrees(fromTree, toTree) {
-			fromOid, toOid := oids[0], oids[1]
-			if oids[0] != oids[1] {
-				var action string
-				if len(fromOid) == 0 && len(toOid) > 0 {
-					action = "new file"
-				} else if len(fromOid) > 0 && len(toOid) == 0 {
-					action = "deleted"
-				} else {
-					action = "modified"
-				}
+// iterChangedFiles returns an iterator of the files that differ between two trees, in path order.
+// Deleted and added files are paired up as renames and copies as opts asks
+func (Diff) iterChangedFiles(fromTree, toTree Tree, opts RenameOptions) (iter.Seq[FileChange], error) {
+	var changes []FileChange
+	var added, deleted []string
+	for path, oids := range diff.compareTrees(fromTree, toTree) {
+		switch fromOid, toOid := oids[0], oids[1]; {
+		case fromOid == toOid:
+		case fromOid == "":
+			added = append(added, path)
+		case toOid == "":
+			deleted = append(deleted, path)
+		default:
+			changes = append(changes, FileChange{path, ACTION_MODIFIED, path, fromOid, toOid, 0})
+		}
+	}
+
+	if opts.Threshold > 0 {
+		renames, err := diff.findRenames(fromTree, toTree, added, deleted, opts)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, renames...)
+		added = slices.DeleteFunc(added, func(path string) bool {
+			return slices.ContainsFunc(renames, func(c FileChange) bool { return c.Path == path })
+		})
+		deleted = slices.DeleteFunc(deleted, func(path string) bool {
+			return slices.ContainsFunc(renames, func(c FileChange) bool {
+				return c.Action == ACTION_RENAMED && c.FromPath == path
+			})
+		})
+	}
+
+	for _, path := range added {
+		changes = append(changes, FileChange{path, ACTION_NEW, "", "", toTree[path], 0})
+	}
+	for _, path := range deleted {
+		changes = append(changes, FileChange{path, ACTION_DELETED, path, fromTree[path], "", 0})
+	}
+	slices.SortFunc(changes, func(a, b FileChange) int {
+		return strings.Compare(strings.ToLower(a.Path), strings.ToLower(b.Path))
+	})
+	return slices.Values(changes), nil
+}
 
-				if !yield(path, action) {
-					return
-				}
+// findRenames pairs each added file with the deleted file most similar to it, and with Copies, the
+// added files left over with the most similar file of fromTree. Files with the same oid are paired
+// before any content is compared. Empty files carry nothing to compare and are never paired
+func (Diff) findRenames(fromTree, toTree Tree, added, deleted []string, opts RenameOptions) ([]FileChange, error) {
+	contents := make(map[string][]byte)
+	read := func(oid string) ([]byte, error) {
+		if buf, ok := contents[oid]; ok {
+			return buf, nil
+		}
+		buf, _, err := data.GetObject(oid)
+		contents[oid] = buf
+		return buf, err
+	}
+
+	var found []FileChange
+	paired := make(map[string]bool)  // Added paths
+	renamed := make(map[string]bool) // Deleted paths
+	pair := func(from, to, action string, score int) {
+		found = append(found, FileChange{to, action, from, fromTree[from], toTree[to], score})
+		paired[to] = true
+		if action == ACTION_RENAMED {
+			renamed[from] = true
+		}
+	}
+
+	for _, to := range added {
+		for _, from := range deleted {
+			if !renamed[from] && fromTree[from] == toTree[to] && toTree[to] != EMPTY_BLOB_OID {
+				pair(from, to, ACTION_RENAMED, 100)
+				break
+			}
+		}
+	}
+
+	// Pairs are made most similar first, so a deleted file goes to the added file closest to it
+	type candidate struct {
+		from, to string
+		score    int
+	}
+	var candidates []candidate
+	for _, to := range added {
+		if paired[to] {
+			continue
+		}
+		for _, from := range deleted {
+			if renamed[from] {
+				continue
+			}
+			score, err := diff.similarity(fromTree[from], toTree[to], read)
+			if err != nil {
+				return nil, err
+			}
+			if score >= opts.Threshold {
+				candidates = append(candidates, candidate{from, to, score})
+			}
+		}
+	}
+	slices.SortStableFunc(candidates, func(a, b candidate) int { return b.score - a.score })
+	for _, c := range candidates {
+		if !paired[c.to] && !renamed[c.from] {
+			pair(c.from, c.to, ACTION_RENAMED, c.score)
+		}
+	}
+
+	if !opts.Copies {
+		return found, nil
+	}
+	sources := slices.Sorted(maps.Keys(fromTree))
+	for _, to := range added {
+		if paired[to] {
+			continue
+		}
+		best := candidate{score: -1}
+		for _, from := range sources {
+			score, err := diff.similarity(fromTree[from], toTree[to], read)
+			if err != nil {
+				return nil, err
+			}
+			if score >= opts.Threshold && score > best.score {
+				best = candidate{from, to, score}
 			}
 		}
+		if best.score > -1 {
+			pair(best.from, best.to, ACTION_COPIED, best.score)
+		}
+	}
+	return found, nil
+}
+
+// similarity returns how much of the larger of two blobs is made of lines the other one has as well,
+// as a percentage. Only blobs with the same oid are 100% similar, and binary blobs are never similar
+// to anything else
+func (Diff) similarity(fromOid, toOid string, read func(string) ([]byte, error)) (int, error) {
+	if fromOid == EMPTY_BLOB_OID || toOid == EMPTY_BLOB_OID {
+		return 0, nil
 	}
+	if fromOid == toOid {
+		return 100, nil
+	}
+	from, err := read(fromOid)
+	if err != nil {
+		return 0, err
+	}
+	to, err := read(toOid)
+	if err != nil {
+		return 0, err
+	}
+	if diff.isBinary(from) || diff.isBinary(to) {
+		return 0, nil
+	}
+
+	counts := make(map[string]int)
+	for _, line := range bytes.SplitAfter(from, []byte("\n")) {
+		counts[string(line)]++
+	}
+	common := 0
+	for _, line := range bytes.SplitAfter(to, []byte("\n")) {
+		if counts[string(line)] > 0 {
+			counts[string(line)]--
+			common += len(line)
+		}
+	}
+	return min(common*100/max(len(from), len(to)), 99), nil
+}
+
+// isBinary returns true if buf has a NUL byte within its first BINARY_CHECK_LENGTH bytes
+func (Diff) isBinary(buf []byte) bool {
+	return bytes.IndexByte(buf[:min(len(buf), BINARY_CHECK_LENGTH)], 0) > -1
 }
 
 // DiffTrees takes two Tree objects and returns a string output of the files that have been changed.
 // This works because the oid is a hash over the content of the file and therefore different oids imply
-// different content. The action parameter either returns the tree diff or merged tree output
-func (Diff) DiffTrees(treeFrom, treeTo Tree) ([]byte, error) {
+// different content. Renamed and copied files are diffed against the file they came from
+func (Diff) DiffTrees(treeFrom, treeTo Tree, opts RenameOptions) ([]byte, error) {
+	changes, err := diff.iterChangedFiles(treeFrom, treeTo, opts)
+	if err != nil {
+		return nil, err
+	}
+
 	var output []byte
-	for path, oids := range diff.compareTrees(treeFrom, treeTo) {
-		if len(oids) != 2 {
-			return []byte{}, fmt.Errorf("expected 2 oids, received %d", len(oids))
-		}
-		if oids[0] == oids[1] {
-			continue
+	for change := range changes {
+		var difference []byte
+		switch change.Action {
+		case ACTION_RENAMED, ACTION_COPIED:
+			output = append(output, change.renameHeader()...)
+			if change.FromOid == change.ToOid {
+				continue
+			}
+			difference, err = diff.DiffRenamedBlobs(change.FromPath, change.Path, []string{change.FromOid, change.ToOid})
+		default:
+			difference, err = diff.DiffBlobs(change.Path, []string{change.FromOid, change.ToOid})
 		}
-		difference, err := diff.DiffBlobs(path, []string{oids[0], oids[1]})
 		if err != nil {
 			return []byte{}, err
 		}
 		output = append(output, difference...)
-
 	}
 	return output, nil
 }
 
-// MergeTrees takes two Trees and returns a map of path to blob
+// renames returns the new path of every file renamed between two trees
+func (Diff) renames(fromTree, toTree Tree) (map[string]string, error) {
+	changes, err := diff.iterChangedFiles(fromTree, toTree, RenameOptions{Threshold: RENAME_THRESHOLD})
+	if err != nil {
+		return nil, err
+	}
+	renames := make(map[string]string)
+	for change := range changes {
+		if change.Action == ACTION_RENAMED {
+			renames[change.FromPath] = change.Path
+		}
+	}
+	return renames, nil
+}
+
+// alignRenames moves a file renamed on only one side of a merge to its new path in the base and on the
+// other side, so that the changes made to it under either name are merged together
+func (Diff) alignRenames(baseTree, headTree, mergeTree Tree) (Tree, Tree, Tree, error) {
+	headRenames, err := diff.renames(baseTree, headTree)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	mergeRenames, err := diff.renames(baseTree, mergeTree)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	aligned := []Tree{maps.Clone(baseTree), maps.Clone(headTree), maps.Clone(mergeTree)}
+	move := func(from, to string, trees ...int) {
+		for _, i := range trees {
+			if oid, ok := aligned[i][from]; ok {
+				delete(aligned[i], from)
+				aligned[i][to] = oid
+			}
+		}
+	}
+	const BASE_TREE, HEAD_TREE, MERGE_TREE = 0, 1, 2
+
+	for from, to := range headRenames {
+		switch mergeTo, ok := mergeRenames[from]; {
+		case ok && mergeTo == to:
+			move(from, to, BASE_TREE)
+		case ok:
+			// Renamed to different paths on each side, both copies are kept
+		case mergeTree[to] == "":
+			move(from, to, BASE_TREE, MERGE_TREE)
+		}
+	}
+	for from, to := range mergeRenames {
+		if _, ok := headRenames[from]; !ok && headTree[to] == "" {
+			move(from, to, BASE_TREE, HEAD_TREE)
+		}
+	}
+	return aligned[BASE_TREE], aligned[HEAD_TREE], aligned[MERGE_TREE], nil
+}
+
+// MergeTrees takes two Trees and returns a map of path to blob. A file renamed on one side takes the
+// changes the other side made to it under its old path
 func (Diff) MergeTrees(baseTree, headTree, mergeTree Tree) (map[string]string, error) {
+	baseTree, headTree, mergeTree, err := diff.alignRenames(baseTree, headTree, mergeTree)
+	if err != nil {
+		return nil, err
+	}
+
 	res := make(map[string]string)
 	for path, oids := range diff.compareTrees(baseTree, headTree, mergeTree) {
 		baseBlob, headBlob, mergeBlob := oids[0], oids[1], oids[2]
-		// If the oids are the same, use the toBlob data
-		if headBlob == mergeBlob {
-			res[path] = headBlob
+		// If only one side changed the file, use that side. An empty oid means the file was deleted
+		if headBlob == mergeBlob || baseBlob == mergeBlob {
+			if headBlob != "" {
+				res[path] = headBlob
+			}
+		} else if baseBlob == headBlob {
+			if mergeBlob != "" {
+				res[path] = mergeBlob
+			}
 		} else {
 			out, err := diff.MergeBlobs(path, []string{headBlob, baseBlob, mergeBlob})
 			if err != nil {
@@ -158,7 +445,7 @@ func (Diff) MergeTrees(baseTree, headTree, mergeTree Tree) (map[string]string, e
 
 // execBlobDiff blobs executes the "diff" shell command on the two specified BLOBs. If the action type is DIFF, the output is
 // the diff of the two blobs. If the action type is MERGE, the output is the merged output of the two blobs
-func (Diff) execBlobDiff(path string, blobs []string, action int) ([]byte, error) {
+func (Diff) execBlobDiff(paths []string, blobs []string, action int) ([]byte, error) {
 	var tempFiles []string
 
 	// Cleanup tmp files
@@ -178,7 +465,7 @@ func (Diff) execBlobDiff(path string, blobs []string, action int) ([]byte, error
 		}
 	}
 
-	cmd, args := diff.getDiffCmdAndArgs(action, path, tempFiles)
+	cmd, args := diff.getDiffCmdAndArgs(action, paths, tempFiles)
 	execCmd := exec.Command(cmd, args...)
 	out, err := execCmd.Output()
 	if err != nil {
@@ -191,11 +478,16 @@ func (Diff) execBlobDiff(path string, blobs []string, action int) ([]byte, error
 }
 
 func (Diff) DiffBlobs(path string, blobs []string) ([]byte, error) {
-	return diff.execBlobDiff(path, blobs, DIFF)
+	return diff.execBlobDiff([]string{path, path}, blobs, DIFF)
+}
+
+// DiffRenamedBlobs diffs two blobs labelled with the different paths they are stored under
+func (Diff) DiffRenamedBlobs(fromPath, toPath string, blobs []string) ([]byte, error) {
+	return diff.execBlobDiff([]string{fromPath, toPath}, blobs, DIFF)
 }
 
 func (Diff) MergeBlobs(path string, blobs []string) ([]byte, error) {
-	return diff.execBlobDiff(path, blobs, MERGE)
+	return diff.execBlobDiff([]string{path}, blobs, MERGE)
 }
 
 func (Diff) PrettyPrint(line string) {
@@ -205,12 +497,37 @@ func (Diff) PrettyPrint(line string) {
 	}
 
 	var color string
-	if trimmed[0] == '+' || strings.HasPrefix(trimmed, "new file") {
+	if trimmed[0] == '+' || strings.HasPrefix(trimmed, "new file") || strings.HasPrefix(trimmed, "renamed") ||
+		strings.HasPrefix(trimmed, "copied") {
 		color = GREEN
 	} else if trimmed[0] == '-' || strings.HasPrefix(trimmed, "deleted") || strings.HasPrefix(trimmed, "modified") {
 		color = RED
 	} else {
 		color = COLORFLUSH
 	}
+	if !diff.useColor() {
+		fmt.Println(line)
+		return
+	}
 	fmt.Printf("%s%s\n%s", color, line, COLORFLUSH)
 }
+
+// useColor returns true if output should be colored. With COLOR_AUTO, it is colored when written to a terminal
+func (Diff) useColor() bool {
+	switch COLOR_OUTPUT {
+	case COLOR_ALWAYS:
+		return true
+	case COLOR_NEVER:
+		return false
+	}
+	info, err := os.Stdout.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0 && os.Getenv("TERM") != "dumb"
+}
+
+// colorize wraps text in color when output is colored
+func (Diff) colorize(text, color string) string {
+	if !diff.useColor() {
+		return text
+	}
+	return color + text + COLORFLUSH
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"iter"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Width of "--stat" output, histogram included
+const DIFF_STAT_WIDTH = 80
+
+// FileStat is a changed file along with the number of lines it gained and lost. Binary files only
+// have their sizes
+type FileStat struct {
+	FileChange
+	Added    int
+	Deleted  int
+	Binary   bool
+	FromSize int
+	ToSize   int
+}
+
+// Stats counts the lines added and deleted in every file that differs between two trees
+func (Diff) Stats(treeFrom, treeTo Tree, opts RenameOptions) ([]FileStat, error) {
+	changes, err := diff.iterChangedFiles(treeFrom, treeTo, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var stats []FileStat
+	for change := range changes {
+		stat := FileStat{FileChange: change}
+		from, to, err := diff.readChange(change)
+		if err != nil {
+			return nil, err
+		}
+		stat.FromSize, stat.ToSize = len(from), len(to)
+		stat.Binary = diff.isBinary(from) || diff.isBinary(to)
+
+		if !stat.Binary && change.FromOid != change.ToOid {
+			hunks, err := patch.getHunks(change.Path, change.FromOid, change.ToOid)
+			if err != nil {
+				return nil, err
+			}
+			for _, h := range hunks {
+				for _, line := range h.Lines {
+					switch line[0] {
+					case '+':
+						stat.Added++
+					case '-':
+						stat.Deleted++
+					}
+				}
+			}
+		}
+		stats = append(stats, stat)
+	}
+	return stats, nil
+}
+
+// readChange returns the content of a changed file on both sides, empty where it does not exist
+func (Diff) readChange(change FileChange) ([]byte, []byte, error) {
+	var blobs [2][]byte
+	for i, oid := range []string{change.FromOid, change.ToOid} {
+		if oid == "" {
+			continue
+		}
+		buf, _, err := data.GetObject(oid)
+		if err != nil {
+			return nil, nil, err
+		}
+		blobs[i] = buf
+	}
+	return blobs[0], blobs[1], nil
+}
+
+// displayPath names a changed file, as "old => new" if it was renamed or copied
+func (c FileChange) displayPath() string {
+	if c.Action == ACTION_RENAMED || c.Action == ACTION_COPIED {
+		return fmt.Sprintf("%s => %s", c.FromPath, c.Path)
+	}
+	return c.Path
+}
+
+// PrintStat prints a line per file with its number of changed lines and a histogram of them, followed
+// by the totals. The histogram is scaled down when the largest change would not fit in DIFF_STAT_WIDTH.
+// Nothing is printed without changes
+func (Diff) PrintStat(stats []FileStat) {
+	if len(stats) == 0 {
+		return
+	}
+	nameWidth, maxChange, insertions, deletions := 0, 0, 0, 0
+	for _, stat := range stats {
+		nameWidth = max(nameWidth, len(stat.displayPath()))
+		maxChange = max(maxChange, stat.Added+stat.Deleted)
+		insertions += stat.Added
+		deletions += stat.Deleted
+	}
+	countWidth := len(strconv.Itoa(maxChange))
+	graphWidth := max(DIFF_STAT_WIDTH-nameWidth-countWidth-5, 10)
+	scale := func(n int) int {
+		if n == 0 || maxChange <= graphWidth {
+			return n
+		}
+		return 1 + n*(graphWidth-1)/maxChange
+	}
+
+	for _, stat := range stats {
+		if stat.Binary {
+			fmt.Printf(" %-*s | Bin %d -> %d bytes\n", nameWidth, stat.displayPath(), stat.FromSize, stat.ToSize)
+			continue
+		}
+		total, plus := scale(stat.Added+stat.Deleted), scale(stat.Added)
+		graph := diff.colorize(strings.Repeat("+", plus), GREEN) + diff.colorize(strings.Repeat("-", total-plus), RED)
+		fmt.Printf(" %-*s | %*d %s\n", nameWidth, stat.displayPath(), countWidth, stat.Added+stat.Deleted, graph)
+	}
+
+	plural := func(n int, singular, plural string) string {
+		if n == 1 {
+			return fmt.Sprintf("%d %s", n, singular)
+		}
+		return fmt.Sprintf("%d %s", n, plural)
+	}
+	summary := " " + plural(len(stats), "file changed", "files changed")
+	if insertions > 0 || deletions == 0 {
+		summary += ", " + plural(insertions, "insertion(+)", "insertions(+)")
+	}
+	if deletions > 0 || insertions == 0 {
+		summary += ", " + plural(deletions, "deletion(-)", "deletions(-)")
+	}
+	fmt.Println(summary)
+}
+
+// PrintNumstat prints the lines added and deleted in each file, tab separated for scripts. Binary
+// files have "-" for both
+func (Diff) PrintNumstat(stats []FileStat) {
+	for _, stat := range stats {
+		if stat.Binary {
+			fmt.Printf("-\t-\t%s\n", stat.displayPath())
+			continue
+		}
+		fmt.Printf("%d\t%d\t%s\n", stat.Added, stat.Deleted, stat.displayPath())
+	}
+}
+
+// PrintNames prints the path of each changed file. With status, each path is preceded by a letter for
+// its action, and renames and copies also give their similarity and the path they came from
+func (Diff) PrintNames(changes iter.Seq[FileChange], status bool) {
+	letters := map[string]string{
+		ACTION_NEW: "A", ACTION_DELETED: "D", ACTION_MODIFIED: "M", ACTION_RENAMED: "R", ACTION_COPIED: "C",
+	}
+	for change := range changes {
+		switch {
+		case !status:
+			fmt.Println(change.Path)
+		case change.Action == ACTION_RENAMED || change.Action == ACTION_COPIED:
+			fmt.Printf("%s%03d\t%s\t%s\n", letters[change.Action], change.Similarity, change.FromPath, change.Path)
+		default:
+			fmt.Printf("%s\t%s\n", letters[change.Action], change.Path)
+		}
+	}
+}
+
+// Splits text into words, runs of whitespace and newlines
+var wordRegex = regexp.MustCompile(`\n|[^\S\n]+|\S+`)
+
+// PrintWordDiff prints the diff between two trees with the changes inside lines shown word by word,
+// removed words as [-word-] and added ones as {+word+}
+func (Diff) PrintWordDiff(treeFrom, treeTo Tree, opts RenameOptions) error {
+	changes, err := diff.iterChangedFiles(treeFrom, treeTo, opts)
+	if err != nil {
+		return err
+	}
+
+	for change := range changes {
+		fromPath := cmp.Or(change.FromPath, change.Path)
+		if change.Action == ACTION_RENAMED || change.Action == ACTION_COPIED {
+			fmt.Print(change.renameHeader())
+			if change.FromOid == change.ToOid {
+				continue
+			}
+		}
+
+		from, to, err := diff.readChange(change)
+		if err != nil {
+			return err
+		}
+		if diff.isBinary(from) || diff.isBinary(to) {
+			fmt.Printf("Binary files a/%s and b/%s differ\n", fromPath, change.Path)
+			continue
+		}
+
+		hunks, err := patch.getHunks(change.Path, change.FromOid, change.ToOid)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("--- a/%s\n+++ b/%s\n", fromPath, change.Path)
+		for _, h := range hunks {
+			fmt.Println(h)
+			fmt.Print(diff.wordDiffHunk(h))
+		}
+	}
+	return nil
+}
+
+// wordDiffHunk returns the lines of a hunk with each run of removed lines and the added lines following
+// it merged word by word
+func (Diff) wordDiffHunk(h Hunk) string {
+	var out strings.Builder
+	var removed, added strings.Builder
+	flush := func() {
+		out.WriteString(diff.diffWords(removed.String(), added.String()))
+		removed.Reset()
+		added.Reset()
+	}
+
+	for _, line := range h.Lines {
+		switch line[0] {
+		case '-':
+			if added.Len() > 0 {
+				flush()
+			}
+			removed.WriteString(line[1:])
+		case '+':
+			added.WriteString(line[1:])
+		default:
+			flush()
+			out.WriteString(line[1:])
+		}
+	}
+	flush()
+
+	if text := out.String(); !strings.HasSuffix(text, "\n") {
+		return text + "\n"
+	}
+	return out.String()
+}
+
+// diffWords returns to with the words of from it lacks marked as removed and its own new words marked
+// as added. Words are matched along the longest common subsequence of the two texts
+func (Diff) diffWords(from, to string) string {
+	a, b := wordRegex.FindAllString(from, -1), wordRegex.FindAllString(to, -1)
+
+	// common[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	common := make([][]int, len(a)+1)
+	for i := range common {
+		common[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				common[i][j] = common[i+1][j+1] + 1
+			} else {
+				common[i][j] = max(common[i+1][j], common[i][j+1])
+			}
+		}
+	}
+
+	var out, removed, inserted strings.Builder
+	flush := func() {
+		out.WriteString(diff.markWords(removed.String(), "[-", "-]", RED))
+		out.WriteString(diff.markWords(inserted.String(), "{+", "+}", GREEN))
+		removed.Reset()
+		inserted.Reset()
+	}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			flush()
+			out.WriteString(a[i])
+			i, j = i+1, j+1
+		case j == len(b) || (i < len(a) && common[i+1][j] >= common[i][j+1]):
+			removed.WriteString(a[i])
+			i++
+		default:
+			inserted.WriteString(b[j])
+			j++
+		}
+	}
+	flush()
+	return out.String()
+}
+
+// markWords wraps each line of text between open and close, keeping the newlines outside of them
+func (Diff) markWords(text, open, close, color string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		if line != "" {
+			lines[i] = diff.colorize(open+line+close, color)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
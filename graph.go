@@ -0,0 +1,108 @@
+package main
+
+import (
+	"slices"
+	"strings"
+)
+
+// LogGraph draws the lanes of "log --graph" one commit at a time. Each lane holds the oid of the
+// commit expected to appear in it next, and commits must be given children first
+type LogGraph struct {
+	lanes []string
+}
+
+type graphEdge struct {
+	from, to int
+}
+
+// Commit returns the row holding the commit, the padding its remaining lines of output are printed
+// after, and the rows that connect its lanes to the lanes of the next commit
+func (g *LogGraph) Commit(oid string, parents []string) (string, string, []string) {
+	idx := slices.Index(g.lanes, oid)
+	if idx == -1 {
+		idx = len(g.lanes)
+		g.lanes = append(g.lanes, oid)
+	}
+
+	row := g.draw(idx, "*")
+	padding := g.draw(idx, "|")
+	if len(parents) == 0 {
+		padding = g.draw(idx, " ")
+	}
+
+	// Parents take over the commit's lane and the lanes opened right of it. Lanes waiting for a
+	// commit that is already in another lane are folded into that lane
+	var next []string
+	var edges []graphEdge
+	for i, lane := range g.lanes {
+		targets := []string{lane}
+		if i == idx {
+			targets = parents
+		}
+		for _, target := range targets {
+			j := slices.Index(next, target)
+			if j == -1 {
+				j = len(next)
+				next = append(next, target)
+			}
+			edges = append(edges, graphEdge{i, j})
+		}
+	}
+	g.lanes = next
+	return row, padding, g.connect(edges)
+}
+
+// draw renders the current lanes with mark in the lane at idx
+func (g *LogGraph) draw(idx int, mark string) string {
+	cells := make([]string, len(g.lanes))
+	for i := range cells {
+		cells[i] = "|"
+	}
+	cells[idx] = mark
+	return strings.TrimRight(strings.Join(cells, " "), " ")
+}
+
+// connect returns the rows moving each edge from the column of its old lane to the column of its
+// new one. Edges move at most one column per row so lanes travelling far are drawn as a slope
+func (LogGraph) connect(edges []graphEdge) []string {
+	positions := make([]int, len(edges))
+	for i, edge := range edges {
+		positions[i] = edge.from * 2
+	}
+
+	var rows []string
+	for {
+		done := true
+		for i, edge := range edges {
+			if positions[i] != edge.to*2 {
+				done = false
+			}
+		}
+		if done {
+			return rows
+		}
+
+		var row []byte
+		put := func(col int, c byte) {
+			for len(row) <= col {
+				row = append(row, ' ')
+			}
+			if row[col] == ' ' {
+				row[col] = c
+			}
+		}
+		for i, edge := range edges {
+			switch target := edge.to * 2; {
+			case positions[i] < target:
+				put(positions[i]+1, '\\')
+				positions[i] += 2
+			case positions[i] > target:
+				put(positions[i]-1, '/')
+				positions[i] -= 2
+			default:
+				put(positions[i], '|')
+			}
+		}
+		rows = append(rows, strings.TrimRight(string(row), " "))
+	}
+}
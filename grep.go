@@ -0,0 +1,170 @@
+package main
+
+import (
+	"os"
+	"regexp"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+)
+
+type Grep struct{}
+
+// Namespacing
+var grep Grep
+
+// Files with a NUL byte this early on are treated as binary, as git does
+const BINARY_CHECK_LENGTH = 8000
+
+// GrepMatch is a line matching a grep pattern
+type GrepMatch struct {
+	Path    string
+	Line    int
+	Content string
+}
+
+type grepFile struct {
+	path string
+	read func() ([]byte, error)
+}
+
+// Compile turns a grep pattern into a regexp. Without extended, the pattern is a basic regular
+// expression in which ?, +, |, braces and parentheses are literal unless escaped
+func (Grep) Compile(pattern string, extended, ignoreCase bool) (*regexp.Regexp, error) {
+	if !extended {
+		var converted strings.Builder
+		for i := 0; i < len(pattern); i++ {
+			c := pattern[i]
+			switch {
+			case c == '\\' && i+1 < len(pattern) && strings.IndexByte("?+|{}()", pattern[i+1]) > -1:
+				converted.WriteByte(pattern[i+1])
+				i++
+			case c == '\\' && i+1 < len(pattern):
+				converted.WriteString(pattern[i : i+2])
+				i++
+			case strings.IndexByte("?+|{}()", c) > -1:
+				converted.WriteString(regexp.QuoteMeta(string(c)))
+			default:
+				converted.WriteByte(c)
+			}
+		}
+		pattern = converted.String()
+	}
+	if ignoreCase {
+		pattern = "(?i)" + pattern
+	}
+	return regexp.Compile(pattern)
+}
+
+// files returns the files to search: the tracked files of the working directory that are not
+// ignored, the index with cached, or the tree of rev when one is given
+func (Grep) files(rev string, cached bool) ([]grepFile, error) {
+	readObject := func(oid string) func() ([]byte, error) {
+		return func() ([]byte, error) {
+			buf, _, err := data.GetObject(oid)
+			return buf, err
+		}
+	}
+
+	var files []grepFile
+	if rev != "" {
+		oid, err := base.GetOid(rev)
+		if err != nil {
+			return nil, err
+		}
+		c, err := base.GetCommit(oid)
+		if err != nil {
+			return nil, err
+		}
+		tree, err := base.GetTree(c.TreeOid, "")
+		if err != nil {
+			return nil, err
+		}
+		for path, oid := range tree {
+			files = append(files, grepFile{path, readObject(oid)})
+		}
+		return files, nil
+	}
+
+	index, err := base.GetIndexTree()
+	if err != nil {
+		return nil, err
+	}
+	for path, oid := range index {
+		if cached {
+			files = append(files, grepFile{path, readObject(oid)})
+			continue
+		}
+		if data.isIgnored(path) {
+			continue
+		}
+		files = append(files, grepFile{path, func() ([]byte, error) {
+			buf, err := os.ReadFile(path)
+			if os.IsNotExist(err) {
+				// Deleted from the working directory but still tracked
+				return nil, nil
+			}
+			return buf, err
+		}})
+	}
+	return files, nil
+}
+
+// Search returns the lines matching re in the files under paths, or every file if none are given.
+// Files are searched in parallel and matches are returned sorted by path and line
+func (Grep) Search(re *regexp.Regexp, rev string, cached bool, paths []string) ([]GrepMatch, error) {
+	files, err := grep.files(rev, cached)
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) > 0 {
+		files = slices.DeleteFunc(files, func(f grepFile) bool {
+			return !base.matchesPaths(f.path, paths)
+		})
+	}
+	slices.SortFunc(files, func(a, b grepFile) int { return strings.Compare(a.path, b.path) })
+
+	results := make([][]GrepMatch, len(files))
+	errs := make([]error, len(files))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for range runtime.NumCPU() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				results[i], errs[i] = grep.searchFile(re, files[i])
+			}
+		}()
+	}
+	for i := range files {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	var matches []GrepMatch
+	for i := range files {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		matches = append(matches, results[i]...)
+	}
+	return matches, nil
+}
+
+func (Grep) searchFile(re *regexp.Regexp, f grepFile) ([]GrepMatch, error) {
+	buf, err := f.read()
+	if err != nil || diff.isBinary(buf) {
+		return nil, err
+	}
+
+	var matches []GrepMatch
+	for i, line := range strings.Split(strings.TrimSuffix(string(buf), "\n"), "\n") {
+		if re.MatchString(line) {
+			matches = append(matches, GrepMatch{f.path, i + 1, line})
+		}
+	}
+	return matches, nil
+}
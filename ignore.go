@@ -0,0 +1,237 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+type Ignore struct{}
+
+// Namespacing
+var ignore Ignore
+
+// Name of the per-directory ignore files
+const IGNORE_FILE = ".gogitignore"
+
+// IgnoreRule is a single compiled pattern from an ignore file
+type IgnoreRule struct {
+	Pattern string // Pattern as written in the source file
+	Source  string // File the pattern was read from
+	Line    int    // Line number of the pattern in Source
+	base    string // Directory the pattern is relative to
+	negate  bool
+	dirOnly bool
+	regex   *regexp.Regexp
+}
+
+type ignoreCacheEntry struct {
+	modTime time.Time
+	size    int64
+	rules   []IgnoreRule
+}
+
+// Ignore files are only re-parsed when they change on disk
+var ignoreCache = map[string]ignoreCacheEntry{}
+
+// The gogit directory is always ignored
+var builtinIgnoreRule = IgnoreRule{
+	Pattern: GOGIT_DIR,
+	Source:  "<builtin>",
+	regex:   regexp.MustCompile("^(?:.*/)?" + regexp.QuoteMeta(GOGIT_DIR) + "$"),
+}
+
+// globalExcludesFile returns the user-wide excludes file. GOGIT_EXCLUDES_FILE takes precedence
+// over $XDG_CONFIG_HOME/gogit/ignore and ~/.config/gogit/ignore
+func (Ignore) globalExcludesFile() string {
+	if fp := os.Getenv("GOGIT_EXCLUDES_FILE"); fp != "" {
+		return fp
+	}
+	if configHome := os.Getenv("XDG_CONFIG_HOME"); configHome != "" {
+		return filepath.Join(configHome, "gogit", "ignore")
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(home, ".config", "gogit", "ignore")
+	}
+	return ""
+}
+
+// compileRule turns a single gitignore-style line into a rule. Returns false for blank lines and comments
+func (Ignore) compileRule(line, base string) (IgnoreRule, bool) {
+	// Trailing spaces are ignored unless escaped
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+		line = line[:len(line)-1]
+	}
+	line = strings.TrimSuffix(line, "\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return IgnoreRule{}, false
+	}
+
+	rule := IgnoreRule{Pattern: line, base: base}
+	pattern := line
+	if strings.HasPrefix(pattern, "!") {
+		rule.negate = true
+		pattern = pattern[1:]
+	} else if strings.HasPrefix(pattern, "\\!") || strings.HasPrefix(pattern, "\\#") {
+		pattern = pattern[1:]
+	}
+
+	if strings.HasSuffix(pattern, "/") {
+		rule.dirOnly = true
+		pattern = strings.TrimRight(pattern, "/")
+	}
+	if pattern == "" {
+		return IgnoreRule{}, false
+	}
+
+	// Patterns with a slash are relative to the ignore file, others match at any depth
+	anchored := strings.Contains(pattern, "/")
+	pattern = strings.TrimPrefix(pattern, "/")
+
+	var re strings.Builder
+	re.WriteString("^")
+	if !anchored {
+		re.WriteString("(?:.*/)?")
+	}
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case strings.HasPrefix(pattern[i:], "**/") && (i == 0 || pattern[i-1] == '/'):
+			re.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**") && i > 0 && pattern[i-1] == '/' && i+2 == len(pattern):
+			re.WriteString(".*")
+			i++
+		case c == '*':
+			re.WriteString("[^/]*")
+		case c == '?':
+			re.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				re.WriteString(regexp.QuoteMeta(string(c)))
+				continue
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			re.WriteString("[" + strings.ReplaceAll(class, "\\", "\\\\") + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(pattern):
+			i++
+			re.WriteString(regexp.QuoteMeta(string(pattern[i])))
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	re.WriteString("$")
+
+	regex, err := regexp.Compile(re.String())
+	if err != nil {
+		return IgnoreRule{}, false
+	}
+	rule.regex = regex
+	return rule, true
+}
+
+// readRules parses the ignore file at fp, whose patterns are relative to the base directory
+func (Ignore) readRules(fp, base string) []IgnoreRule {
+	info, err := os.Stat(fp)
+	if err != nil {
+		delete(ignoreCache, fp)
+		return nil
+	}
+
+	if cached, ok := ignoreCache[fp]; ok && cached.modTime.Equal(info.ModTime()) && cached.size == info.Size() {
+		return cached.rules
+	}
+
+	buf, err := os.ReadFile(fp)
+	if err != nil {
+		return nil
+	}
+
+	var rules []IgnoreRule
+	for i, line := range strings.Split(string(buf), "\n") {
+		if rule, ok := ignore.compileRule(line, base); ok {
+			rule.Source = fp
+			rule.Line = i + 1
+			rules = append(rules, rule)
+		}
+	}
+	ignoreCache[fp] = ignoreCacheEntry{info.ModTime(), info.Size(), rules}
+	return rules
+}
+
+// rulesFor returns every rule that applies to path, from lowest to highest precedence
+func (Ignore) rulesFor(path string) []IgnoreRule {
+	rules := []IgnoreRule{builtinIgnoreRule}
+	if fp := ignore.globalExcludesFile(); fp != "" {
+		rules = append(rules, ignore.readRules(fp, "")...)
+	}
+	rules = append(rules, ignore.readRules(filepath.Join(GOGIT_ROOT, "info", "exclude"), "")...)
+	rules = append(rules, ignore.readRules(IGNORE_FILE, "")...)
+
+	// Ignore files in deeper directories take precedence over those above them
+	dir := ""
+	for _, component := range strings.Split(filepath.Dir(path), "/") {
+		if component == "." {
+			break
+		}
+		dir = filepath.Join(dir, component)
+		rules = append(rules, ignore.readRules(filepath.Join(dir, IGNORE_FILE), dir)...)
+	}
+	return rules
+}
+
+func (rule IgnoreRule) matches(path string, isDir bool) bool {
+	if rule.dirOnly && !isDir {
+		return false
+	}
+
+	rel := path
+	if rule.base != "" {
+		var ok bool
+		if rel, ok = strings.CutPrefix(path, rule.base+"/"); !ok {
+			return false
+		}
+	}
+	return rule.regex.MatchString(rel)
+}
+
+// matchPath returns the last rule matching path itself, ignoring its parent directories
+func (Ignore) matchPath(path string, isDir bool) *IgnoreRule {
+	var match *IgnoreRule
+	rules := ignore.rulesFor(path)
+	for i := range rules {
+		if rules[i].matches(path, isDir) {
+			match = &rules[i]
+		}
+	}
+	return match
+}
+
+// Match returns the rule deciding whether path is ignored, or nil if no rule matches.
+// A path inside an ignored directory is ignored by the directory's rule and cannot be re-included
+func (Ignore) Match(path string) *IgnoreRule {
+	path = filepath.ToSlash(filepath.Clean(path))
+	if path == "." || path == "" {
+		return nil
+	}
+
+	components := strings.Split(path, "/")
+	for i := 1; i < len(components); i++ {
+		if rule := ignore.matchPath(strings.Join(components[:i], "/"), true); rule != nil && !rule.negate {
+			return rule
+		}
+	}
+
+	isDir := false
+	if info, err := os.Lstat(path); err == nil {
+		isDir = info.IsDir()
+	}
+	return ignore.matchPath(path, isDir)
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+type LFS struct{}
+
+// Namespacing
+var lfs LFS
+
+// File in the working directory listing the path patterns stored as large files
+const LFS_PATTERNS_FILE = ".gogitlfs"
+
+// First line of every pointer blob
+const LFS_POINTER_HEADER = "version gogit-lfs/1"
+
+// Pointer blobs are tiny, anything larger cannot be a pointer
+const LFS_POINTER_MAX_SIZE = 256
+
+func (LFS) objectsDir() string {
+	return filepath.Join(GOGIT_ROOT, "lfs", "objects")
+}
+
+func (LFS) objectPath(oid string) string {
+	return filepath.Join(lfs.objectsDir(), oid)
+}
+
+// remoteFile stores the path of the last fetched remote so large contents can be pulled on demand
+func (LFS) remoteFile() string {
+	return filepath.Join(GOGIT_ROOT, "lfs", "remote")
+}
+
+func (LFS) patterns() []string {
+	buf, err := os.ReadFile(filepath.Join(".", LFS_PATTERNS_FILE))
+	if err != nil {
+		return nil
+	}
+
+	var patterns []string
+	for _, line := range strings.Split(string(buf), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			patterns = append(patterns, line)
+		}
+	}
+	return patterns
+}
+
+// isTracked returns true if the path matches one of the large file patterns. Patterns follow
+// the same syntax as ignore files, so a later "!" pattern stops tracking a path. They are read
+// like ignore files too, only compiled again once the patterns file changes
+func (LFS) isTracked(path string) bool {
+	path = filepath.ToSlash(filepath.Clean(path))
+	tracked := false
+	for _, rule := range ignore.readRules(filepath.Join(".", LFS_PATTERNS_FILE), "") {
+		if rule.matches(path, false) {
+			tracked = !rule.negate
+		}
+	}
+	return tracked
+}
+
+// Track adds a pattern to the large file patterns file
+func (LFS) Track(pattern string) error {
+	for _, p := range lfs.patterns() {
+		if p == pattern {
+			return nil
+		}
+	}
+
+	f, err := os.OpenFile(filepath.Join(".", LFS_PATTERNS_FILE), os.O_APPEND|os.O_CREATE|os.O_WRONLY, FP)
+	if err != nil {
+		return err
+	}
+	if _, err = fmt.Fprintln(f, pattern); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Untrack removes a pattern from the large file patterns file
+func (LFS) Untrack(pattern string) error {
+	var kept []string
+	for _, p := range lfs.patterns() {
+		if p != pattern {
+			kept = append(kept, p)
+		}
+	}
+
+	content := strings.Join(kept, "\n")
+	if len(kept) > 0 {
+		content += "\n"
+	}
+	return os.WriteFile(filepath.Join(".", LFS_PATTERNS_FILE), []byte(content), FP)
+}
+
+func (LFS) pointer(oid string, size int64) []byte {
+	return []byte(fmt.Sprintf("%s\noid %s\nsize %d\n", LFS_POINTER_HEADER, oid, size))
+}
+
+// parsePointer returns the large file oid and size described by a pointer blob
+func (LFS) parsePointer(buf []byte) (string, int64, bool) {
+	lines := strings.Split(strings.TrimSpace(string(buf)), "\n")
+	if len(lines) != 3 || lines[0] != LFS_POINTER_HEADER {
+		return "", 0, false
+	}
+
+	oid, ok := strings.CutPrefix(lines[1], "oid ")
+	if !ok || !data.isValidSHA1(oid) {
+		return "", 0, false
+	}
+
+	sizeStr, ok := strings.CutPrefix(lines[2], "size ")
+	if !ok {
+		return "", 0, false
+	}
+	size, err := strconv.ParseInt(sizeStr, 10, 64)
+	if err != nil {
+		return "", 0, false
+	}
+	return oid, size, true
+}
+
+// readPointer inspects a blob and, if it is a pointer, returns the large file oid it points to.
+// Only the first few bytes of the blob are read so large regular blobs are not loaded into memory
+func (LFS) readPointer(blobOid string) (string, bool, error) {
+	r, t, err := data.OpenObject(blobOid)
+	if err != nil {
+		return "", false, err
+	}
+	defer r.Close()
+
+	if t != BLOB {
+		return "", false, nil
+	}
+
+	buf, err := io.ReadAll(io.LimitReader(r, LFS_POINTER_MAX_SIZE+1))
+	if err != nil {
+		return "", false, err
+	}
+	if len(buf) > LFS_POINTER_MAX_SIZE {
+		return "", false, nil
+	}
+
+	oid, _, ok := lfs.parsePointer(buf)
+	return oid, ok, nil
+}
+
+func (LFS) hashContent(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+
+	hasher := sha1.New()
+	size, err := io.Copy(hasher, bufio.NewReader(f))
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), size, nil
+}
+
+// hashFile copies the file at path into the large file store and returns the oid of its pointer blob
+func (LFS) hashFile(path string) (string, error) {
+	oid, size, err := lfs.hashContent(path)
+	if err != nil {
+		return "", err
+	}
+
+	// Content is only copied into the store the first time it is seen
+	if _, err := os.Stat(lfs.objectPath(oid)); os.IsNotExist(err) {
+		if err := os.MkdirAll(lfs.objectsDir(), FP); err != nil {
+			return "", err
+		}
+
+		tmp := lfs.objectPath(oid) + ".tmp"
+		if err := data.copyFile(path, tmp); err != nil {
+			os.Remove(tmp)
+			return "", err
+		}
+		if err := os.Rename(tmp, lfs.objectPath(oid)); err != nil {
+			return "", err
+		}
+	}
+
+	return data.HashObject(lfs.pointer(oid, size), BLOB)
+}
+
+// materialise writes the large file content a pointer refers to into path, pulling it
+// from the last fetched remote if it is not present locally
+func (LFS) materialise(oid, path string) error {
+	if _, err := os.Stat(lfs.objectPath(oid)); os.IsNotExist(err) {
+		if err := lfs.fetchObject(oid); err != nil {
+			return err
+		}
+	}
+	return data.copyFile(lfs.objectPath(oid), path)
+}
+
+// SetRemote records the remote large file contents are fetched from
+func (LFS) SetRemote(remotePath string) error {
+	if err := os.MkdirAll(filepath.Dir(lfs.remoteFile()), FP); err != nil {
+		return err
+	}
+
+	abs, err := filepath.Abs(remotePath)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(lfs.remoteFile(), []byte(abs), FP)
+}
+
+func (LFS) fetchObject(oid string) error {
+	remotePath, err := os.ReadFile(lfs.remoteFile())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("large file %s is not available locally and no remote has been fetched", oid)
+		}
+		return err
+	}
+
+	src := filepath.Join(string(remotePath), "lfs", "objects", oid)
+	if _, err := os.Stat(src); err != nil {
+		return fmt.Errorf("large file %s not found on remote %s", oid, remotePath)
+	}
+
+	if err := os.MkdirAll(lfs.objectsDir(), FP); err != nil {
+		return err
+	}
+	return data.copyFile(src, lfs.objectPath(oid))
+}
+
+// pushObject copies a large file's content into the remote store if the remote does not have it yet
+func (LFS) pushObject(oid, remotePath string) error {
+	dst := filepath.Join(remotePath, "lfs", "objects", oid)
+	if _, err := os.Stat(dst); err == nil {
+		return nil
+	}
+
+	if _, err := os.Stat(lfs.objectPath(oid)); err != nil {
+		return fmt.Errorf("large file %s is not available locally", oid)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dst), FP); err != nil {
+		return err
+	}
+	return data.copyFile(lfs.objectPath(oid), dst)
+}
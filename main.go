@@ -1,10 +1,17 @@
 package main
 
 import (
+	"cmp"
 	"flag"
 	"fmt"
+	"io"
+	"maps"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -56,22 +63,43 @@ func (CLI) Init(_ CLIArgs, _ CLIFlags) error {
 }
 
 func (CLI) CatFile(args CLIArgs, _ CLIFlags) error {
-	fp := args[0]
-	buf, _, err := data.GetObject(fp)
+	oid, err := base.GetOid(args[0])
 	if err != nil {
 		return err
 	}
-	fmt.Printf("%s\n", string(buf))
+	r, _, err := data.OpenObject(oid)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	if _, err = io.Copy(os.Stdout, r); err != nil {
+		return err
+	}
+	fmt.Println()
 	return nil
 }
 
 func (CLI) Commit(_ CLIArgs, flags CLIFlags) error {
-	message := flags["message"].(string)
-	if message == "" {
+	message, _ := flags["message"].(string)
+	amend, _ := flags["amend"].(bool)
+	if message == "" && !amend {
 		return fmt.Errorf("message must have length greater than 0")
 	}
 
-	oid, err := base.Commit(message, time.Now())
+	if all, ok := flags["all"].(bool); ok && all {
+		if err := base.StageTracked(); err != nil {
+			return err
+		}
+	}
+
+	var oid string
+	var err error
+	if amend {
+		oid, err = base.Amend(message, time.Now())
+	} else {
+		oid, err = base.Commit(message, time.Now())
+	}
 	if err != nil {
 		return err
 	}
@@ -79,17 +107,200 @@ func (CLI) Commit(_ CLIArgs, flags CLIFlags) error {
 	return nil
 }
 
-func (CLI) Log(args CLIArgs, _ CLIFlags) error {
-	var oid string
+func (CLI) Log(args CLIArgs, flags CLIFlags) error {
+	opts := LogOptions{
+		Reverse:     flags["reverse"] != nil,
+		FirstParent: flags["first-parent"] != nil,
+		Graph:       flags["graph"] != nil,
+	}
+	order, err := walkOrder(flags)
+	if err != nil {
+		return err
+	}
+	opts.Order = order
+	if opts.Graph && opts.Reverse {
+		return fmt.Errorf("--reverse and --graph cannot be used together")
+	}
+	opts.MaxCount, _ = flags["max-count"].(int)
+	opts.Paths, _ = flags["paths"].(CLIArgs)
+	if flags["follow"] != nil {
+		if len(opts.Paths) != 1 {
+			return fmt.Errorf("--follow requires exactly one path")
+		}
+		if opts.Follow, err = renameOptions(flags); err != nil {
+			return err
+		}
+	}
+
+	if flags["oneline"] != nil {
+		opts.Format = "%h%d %s"
+	}
+	if format, ok := flags["format"].(string); ok {
+		// git's "format:" and "tformat:" prefixes are accepted but make no difference here
+		if rest, ok := strings.CutPrefix(format, "tformat:"); ok {
+			format = rest
+		}
+		opts.Format = strings.TrimPrefix(format, "format:")
+	}
+
+	switch {
+	case flags["merges"] != nil && flags["no-merges"] != nil:
+		return fmt.Errorf("--merges and --no-merges are mutually exclusive")
+	case flags["merges"] != nil:
+		opts.Merges = MERGES_ONLY
+	case flags["no-merges"] != nil:
+		opts.Merges = MERGES_EXCLUDED
+	}
+
+	if since, ok := flags["since"].(string); ok {
+		if opts.Since, err = parseDate(since, time.Now()); err != nil {
+			return err
+		}
+	}
+	if until, ok := flags["until"].(string); ok {
+		if opts.Until, err = parseDate(until, time.Now()); err != nil {
+			return err
+		}
+	}
+	if author, ok := flags["author"].(string); ok {
+		if opts.Author, err = regexp.Compile(author); err != nil {
+			return err
+		}
+	}
+	opts.Pickaxe, _ = flags["pickaxe"].(string)
+	if pattern, ok := flags["pickaxe-regex"].(string); ok {
+		if opts.PickaxeRegex, err = regexp.Compile(pattern); err != nil {
+			return err
+		}
+	}
+	if pattern, ok := flags["grep"].(string); ok {
+		if opts.Grep, err = regexp.Compile(pattern); err != nil {
+			return err
+		}
+	}
+	return base.Log(args, opts)
+}
+
+// renameOptions returns the rename detection selected by the -M, -C and --no-renames flags. -M takes
+// the similarity threshold as a percentage
+func renameOptions(flags CLIFlags) (RenameOptions, error) {
+	opts := RenameOptions{Threshold: RENAME_THRESHOLD, Copies: flags["find-copies"] != nil}
+	threshold, ok := flags["find-renames"].(string)
+	if flags["no-renames"] != nil {
+		if ok || opts.Copies {
+			return RenameOptions{}, fmt.Errorf("--no-renames cannot be combined with -M or -C")
+		}
+		return RenameOptions{}, nil
+	}
+	if ok {
+		n, err := strconv.Atoi(strings.TrimSuffix(threshold, "%"))
+		if err != nil || n < 1 || n > 100 {
+			return RenameOptions{}, fmt.Errorf("invalid rename threshold \"%s\", expected a percentage from 1 to 100", threshold)
+		}
+		opts.Threshold = n
+	}
+	return opts, nil
+}
+
+// walkOrder returns the history order selected by the --date-order, --author-date-order and --topo-order flags
+func walkOrder(flags CLIFlags) (int, error) {
+	order := ORDER_DEFAULT
+	for name, o := range map[string]int{"date-order": ORDER_DATE, "author-date-order": ORDER_AUTHOR_DATE, "topo-order": ORDER_TOPO} {
+		if flags[name] == nil {
+			continue
+		}
+		if order != ORDER_DEFAULT {
+			return 0, fmt.Errorf("only one of --date-order, --author-date-order and --topo-order can be given")
+		}
+		order = o
+	}
+	return order, nil
+}
+
+func (CLI) Blame(args CLIArgs, flags CLIFlags) error {
+	rev, path := HEAD, args[0]
 	if len(args) > 1 {
-		oid = args[0]
-	} else {
-		oid = "@"
+		rev, path = args[0], args[1]
 	}
-	return base.Log(oid)
+
+	lines, commits, err := blame.Lines(rev, path)
+	if err != nil {
+		return err
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+
+	start, end := 1, len(lines)
+	if spec, ok := flags["line-range"].(string); ok {
+		if start, end, err = blame.ParseRange(spec, len(lines)); err != nil {
+			return err
+		}
+	}
+	blame.Print(lines, commits, path, start, end, flags["porcelain"] != nil)
+	return nil
+}
+
+func (CLI) Grep(args CLIArgs, flags CLIFlags) error {
+	rev, pattern := "", args[0]
+	if len(args) > 1 {
+		rev, pattern = args[0], args[1]
+	}
+	cached := flags["cached"] != nil
+	if cached && rev != "" {
+		return fmt.Errorf("--cached cannot be used with a revision")
+	}
+
+	re, err := grep.Compile(pattern, flags["extended-regexp"] != nil, flags["ignore-case"] != nil)
+	if err != nil {
+		return err
+	}
+	paths, _ := flags["paths"].(CLIArgs)
+	matches, err := grep.Search(re, rev, cached, paths)
+	if err != nil {
+		return err
+	}
+
+	for _, match := range matches {
+		prefix := match.Path
+		if rev != "" {
+			prefix = rev + ":" + prefix
+		}
+		if flags["line-number"] != nil {
+			prefix += fmt.Sprintf(":%d", match.Line)
+		}
+		fmt.Printf("%s:%s\n", prefix, match.Content)
+	}
+	if len(matches) == 0 {
+		return fmt.Errorf("no matches found")
+	}
+	return nil
+}
+
+func (CLI) RevList(args CLIArgs, flags CLIFlags) error {
+	order, err := walkOrder(flags)
+	if err != nil {
+		return err
+	}
+	commits, err := base.RevList(args, flags["first-parent"] != nil, order)
+	if err != nil {
+		return err
+	}
+	for oid := range commits {
+		fmt.Println(oid)
+	}
+	return nil
 }
 
 func (CLI) Checkout(args CLIArgs, flags CLIFlags) error {
+	// Checking out paths restores them without switching branches
+	if paths, ok := flags["paths"].(CLIArgs); ok {
+		if len(args) == 0 {
+			return base.Restore("", paths, false, true)
+		}
+		return base.Restore(args[0], paths, true, true)
+	}
+
 	branchFlagName, branchFlagExists := flags["branch"].(string)
 	if !branchFlagExists && len(args) == 0 {
 		return fmt.Errorf("not enough args, require -b or branch name")
@@ -113,7 +324,7 @@ func (CLI) Checkout(args CLIArgs, flags CLIFlags) error {
 		return nil
 	}
 
-	if err := base.Checkout(branchName, branchFlagExists); err != nil {
+	if err := base.Checkout(branchName, branchFlagExists, checkoutMode(flags)); err != nil {
 		return err
 	}
 
@@ -121,6 +332,25 @@ func (CLI) Checkout(args CLIArgs, flags CLIFlags) error {
 	return nil
 }
 
+func (CLI) Restore(args CLIArgs, flags CLIFlags) error {
+	paths := args
+	if p, ok := flags["paths"].(CLIArgs); ok {
+		paths = append(paths, p...)
+	}
+	source, _ := flags["source"].(string)
+	if p, ok := flags["patch"].(bool); ok && p {
+		return base.RestorePatch(source, paths...)
+	}
+
+	if len(paths) == 0 {
+		return fmt.Errorf("not enough args, require at least one path to restore")
+	}
+
+	staged, _ := flags["staged"].(bool)
+	worktree, _ := flags["worktree"].(bool)
+	return base.Restore(source, paths, staged, worktree)
+}
+
 func (CLI) Tag(args CLIArgs, _ CLIFlags) error {
 	name, oid := args[0], args[1]
 	if err := base.CreateTag(name, oid); err != nil {
@@ -130,6 +360,17 @@ func (CLI) Tag(args CLIArgs, _ CLIFlags) error {
 	return nil
 }
 
+func (CLI) RevParse(args CLIArgs, _ CLIFlags) error {
+	for _, rev := range args {
+		oid, err := base.GetOid(rev)
+		if err != nil {
+			return err
+		}
+		fmt.Println(oid)
+	}
+	return nil
+}
+
 func (CLI) K(_ CLIArgs, _ CLIFlags) error {
 	return base.K()
 }
@@ -164,7 +405,12 @@ func (CLI) Branch(args CLIArgs, _ CLIFlags) error {
 	return nil
 }
 
-func (CLI) Status(_ CLIArgs, _ CLIFlags) error {
+func (CLI) Status(_ CLIArgs, flags CLIFlags) error {
+	renames, err := renameOptions(flags)
+	if err != nil {
+		return err
+	}
+
 	headOID, err := base.GetOid("@")
 	if err != nil {
 		return err
@@ -215,28 +461,71 @@ func (CLI) Status(_ CLIArgs, _ CLIFlags) error {
 		return err
 	}
 
-	for path, action := range diff.iterChangedFiles(headTree, indexTree) {
-		diff.PrettyPrint(fmt.Sprintf("%s: %s", action, path))
+	staged, err := diff.iterChangedFiles(headTree, indexTree, renames)
+	if err != nil {
+		return err
+	}
+	for change := range staged {
+		diff.PrettyPrint(change.String())
 	}
 
 	fmt.Printf("\nChanges not staged for commit:\n")
-	for path, action := range diff.iterChangedFiles(indexTree, workingTree) {
-		diff.PrettyPrint(fmt.Sprintf("%s: %s", action, path))
+	unstaged, err := diff.iterChangedFiles(indexTree, workingTree, renames)
+	if err != nil {
+		return err
+	}
+	for change := range unstaged {
+		diff.PrettyPrint(change.String())
 	}
 	return nil
 }
 
-func (CLI) Reset(args CLIArgs, _ CLIFlags) error {
-	oid := args[0]
-	if err := base.Reset(oid); err != nil {
+func (CLI) Reset(args CLIArgs, flags CLIFlags) error {
+	oid := "@"
+	if len(args) > 0 {
+		oid = args[0]
+	}
+
+	mode := RESET_MIXED
+	modeFlags := 0
+	for name, m := range map[string]int{"soft": RESET_SOFT, "mixed": RESET_MIXED, "hard": RESET_HARD} {
+		if set, ok := flags[name].(bool); ok && set {
+			mode = m
+			modeFlags++
+		}
+	}
+	if modeFlags > 1 {
+		return fmt.Errorf("only one of --soft, --mixed and --hard may be given")
+	}
+
+	paths, hasPaths := flags["paths"].(CLIArgs)
+	if p, ok := flags["patch"].(bool); ok && p {
+		if modeFlags > 0 {
+			return fmt.Errorf("cannot combine --patch with --soft, --mixed or --hard")
+		}
+		return base.ResetPatch(oid, paths...)
+	}
+
+	// Resetting paths only unstages them, HEAD stays where it is
+	if hasPaths {
+		if mode != RESET_MIXED {
+			return fmt.Errorf("cannot do a soft or hard reset with paths")
+		}
+		return base.Restore(oid, paths, true, false)
+	}
+
+	if err := base.Reset(oid, mode); err != nil {
 		return err
 	}
 	fmt.Printf("%s\n", oid)
 	return nil
 }
 
-func (CLI) Show(args CLIArgs, _ CLIFlags) error {
-	oid := args[0]
+func (CLI) Show(args CLIArgs, flags CLIFlags) error {
+	oid, err := base.GetOid(args[0])
+	if err != nil {
+		return err
+	}
 	c, err := base.GetCommit(oid)
 	if err != nil {
 		return err
@@ -262,14 +551,7 @@ func (CLI) Show(args CLIArgs, _ CLIFlags) error {
 		return err
 	}
 
-	out, err := diff.DiffTrees(parentTree, tree)
-	if err != nil {
-		return err
-	}
-	for _, line := range strings.Split(string(out), "\n") {
-		diff.PrettyPrint(line)
-	}
-	return nil
+	return printTreeDiff(parentTree, tree, flags)
 }
 
 func (CLI) Diff(args CLIArgs, flags CLIFlags) error {
@@ -277,7 +559,10 @@ func (CLI) Diff(args CLIArgs, flags CLIFlags) error {
 
 	var treeFrom, treeTo Tree
 	if commitProvided {
-		commitOid := args[0]
+		commitOid, err := base.GetOid(args[0])
+		if err != nil {
+			return err
+		}
 		commit, err := base.GetCommit(commitOid)
 		if err != nil {
 			return err
@@ -306,7 +591,7 @@ func (CLI) Diff(args CLIArgs, flags CLIFlags) error {
 				if err != nil {
 					return err
 				}
-				treeFrom, err = base.GetTree(oid, c.TreeOid)
+				treeFrom, err = base.GetTree(c.TreeOid, "")
 				if err != nil {
 					return err
 				}
@@ -327,19 +612,60 @@ func (CLI) Diff(args CLIArgs, flags CLIFlags) error {
 		}
 	}
 
-	out, err := diff.DiffTrees(treeFrom, treeTo)
+	return printTreeDiff(treeFrom, treeTo, flags)
+}
+
+// printTreeDiff prints the difference between two trees as a patch, or in the output mode selected by
+// the --stat, --numstat, --name-only, --name-status and --word-diff flags
+func printTreeDiff(treeFrom, treeTo Tree, flags CLIFlags) error {
+	renames, err := renameOptions(flags)
 	if err != nil {
 		return err
 	}
-	for _, line := range strings.Split(string(out), "\n") {
-		diff.PrettyPrint(line)
+	modes := 0
+	for _, name := range []string{"stat", "numstat", "name-only", "name-status", "word-diff"} {
+		if flags[name] != nil {
+			modes++
+		}
+	}
+	if modes > 1 {
+		return fmt.Errorf("only one of --stat, --numstat, --name-only, --name-status and --word-diff can be given")
+	}
+
+	switch {
+	case flags["stat"] != nil, flags["numstat"] != nil:
+		stats, err := diff.Stats(treeFrom, treeTo, renames)
+		if err != nil {
+			return err
+		}
+		if flags["stat"] != nil {
+			diff.PrintStat(stats)
+		} else {
+			diff.PrintNumstat(stats)
+		}
+	case flags["name-only"] != nil, flags["name-status"] != nil:
+		changes, err := diff.iterChangedFiles(treeFrom, treeTo, renames)
+		if err != nil {
+			return err
+		}
+		diff.PrintNames(changes, flags["name-status"] != nil)
+	case flags["word-diff"] != nil:
+		return diff.PrintWordDiff(treeFrom, treeTo, renames)
+	default:
+		out, err := diff.DiffTrees(treeFrom, treeTo, renames)
+		if err != nil {
+			return err
+		}
+		for _, line := range strings.Split(string(out), "\n") {
+			diff.PrettyPrint(line)
+		}
 	}
 	return nil
 }
 
-func (CLI) Merge(args CLIArgs, _ CLIFlags) error {
+func (CLI) Merge(args CLIArgs, flags CLIFlags) error {
 	oid := args[0]
-	if err := base.Merge(oid); err != nil {
+	if err := base.Merge(oid, checkoutMode(flags)); err != nil {
 		return err
 	}
 	fmt.Println("Merged in working tree. Please commit")
@@ -381,10 +707,36 @@ func (CLI) Push(args CLIArgs, _ CLIFlags) error {
 	return remote.Push(remotePath, refName)
 }
 
-func (CLI) Add(args CLIArgs, _ CLIFlags) error {
+func (CLI) Add(args CLIArgs, flags CLIFlags) error {
+	if p, ok := flags["patch"].(bool); ok && p {
+		return base.AddPatch(args...)
+	}
+	if all, ok := flags["stage-all"].(bool); ok && all {
+		return base.AddAll(args...)
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("nothing specified, nothing added")
+	}
 	return base.Add(args...)
 }
 
+func (CLI) Rm(args CLIArgs, flags CLIFlags) error {
+	cached, _ := flags["cached"].(bool)
+	recursive, _ := flags["recursive"].(bool)
+	force, _ := flags["force"].(bool)
+	if err := base.Remove(args, cached, recursive, force); err != nil {
+		return err
+	}
+	for _, path := range args {
+		fmt.Printf("rm '%s'\n", path)
+	}
+	return nil
+}
+
+func (CLI) Mv(args CLIArgs, _ CLIFlags) error {
+	return base.Move(args[0], args[1])
+}
+
 func (CLI) ReadIndex(_ CLIArgs, _ CLIFlags) error {
 	idx, err := base.getStructuredIndex()
 	if err != nil {
@@ -400,15 +752,426 @@ func (CLI) GC(_ CLIArgs, _ CLIFlags) error {
 		return err
 	}
 	fmt.Printf("Removed %d unreachable objects\n", unreachable)
+
+	_, err = data.PackRefs(true)
+	return err
+}
+
+func (CLI) PackRefs(_ CLIArgs, flags CLIFlags) error {
+	all, _ := flags["all"].(bool)
+	packed, err := data.PackRefs(all)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Packed %d refs\n", packed)
+	return nil
+}
+
+func (CLI) LFS(args CLIArgs, _ CLIFlags) error {
+	switch args[0] {
+	case "track":
+		if len(args) < 2 {
+			for _, pattern := range lfs.patterns() {
+				fmt.Println(pattern)
+			}
+			return nil
+		}
+		for _, pattern := range args[1:] {
+			if err := lfs.Track(pattern); err != nil {
+				return err
+			}
+			fmt.Printf("Tracking \"%s\"\n", pattern)
+		}
+		return nil
+	case "untrack":
+		if len(args) < 2 {
+			return fmt.Errorf("not enough args, require a pattern to untrack")
+		}
+		for _, pattern := range args[1:] {
+			if err := lfs.Untrack(pattern); err != nil {
+				return err
+			}
+			fmt.Printf("Untracking \"%s\"\n", pattern)
+		}
+		return nil
+	case "ls-files":
+		index, err := base.GetIndexTree()
+		if err != nil {
+			return err
+		}
+		paths := make([]string, 0, len(index))
+		for path := range index {
+			paths = append(paths, path)
+		}
+		slices.Sort(paths)
+
+		for _, path := range paths {
+			lfsOid, isPointer, err := lfs.readPointer(index[path])
+			if err != nil {
+				return err
+			}
+			if isPointer {
+				fmt.Printf("%s %s\n", lfsOid[:10], path)
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown lfs subcommand \"%s\"", args[0])
+	}
+}
+
+func (CLI) CheckIgnore(args CLIArgs, flags CLIFlags) error {
+	verbose, _ := flags["verbose"].(bool)
+	for _, path := range args {
+		rule := ignore.Match(path)
+		if rule == nil || (rule.negate && !verbose) {
+			continue
+		}
+
+		if verbose {
+			fmt.Printf("%s:%d:%s\t%s\n", rule.Source, rule.Line, rule.Pattern, path)
+		} else {
+			fmt.Println(path)
+		}
+	}
 	return nil
 }
 
-func parseFlags(flags CLIFlags, args CLIArgs, flagIdx int) (CLIFlags, CLIArgs, error) {
+func (CLI) Stash(args CLIArgs, flags CLIFlags) error {
+	subcommand := "push"
+	if len(args) > 0 {
+		subcommand, args = args[0], args[1:]
+	}
+	name := ""
+	if len(args) > 0 {
+		name = args[0]
+	}
+
+	switch subcommand {
+	case "push", "save":
+		message, _ := flags["message"].(string)
+		includeUntracked, _ := flags["include-untracked"].(bool)
+		keepIndex, _ := flags["keep-index"].(bool)
+		message, err := stash.Push(message, includeUntracked, keepIndex, time.Now())
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Saved working directory and index state %s\n", message)
+		return nil
+	case "list":
+		entries, err := stash.List()
+		if err != nil {
+			return err
+		}
+		for i, entry := range entries {
+			fmt.Printf("stash@{%d}: %s\n", i, entry.Message)
+		}
+		return nil
+	case "show":
+		baseTree, stashTree, err := stash.Trees(name)
+		if err != nil {
+			return err
+		}
+		if showPatch, _ := flags["patch"].(bool); showPatch {
+			out, err := diff.DiffTrees(baseTree, stashTree, RenameOptions{Threshold: RENAME_THRESHOLD})
+			if err != nil {
+				return err
+			}
+			for _, line := range strings.Split(string(out), "\n") {
+				diff.PrettyPrint(line)
+			}
+			return nil
+		}
+		changes, err := diff.iterChangedFiles(baseTree, stashTree, RenameOptions{Threshold: RENAME_THRESHOLD})
+		if err != nil {
+			return err
+		}
+		for change := range changes {
+			fmt.Println(change)
+		}
+		return nil
+	case "apply":
+		return stash.Apply(name)
+	case "pop":
+		oid, err := stash.Pop(name)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Dropped %s (%s)\n", cmp.Or(name, "stash@{0}"), oid)
+		return nil
+	case "drop":
+		oid, err := stash.Drop(name)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Dropped %s (%s)\n", cmp.Or(name, "stash@{0}"), oid)
+		return nil
+	case "clear":
+		return stash.Clear()
+	default:
+		return fmt.Errorf("unknown stash subcommand \"%s\"", subcommand)
+	}
+}
+
+func (CLI) Reflog(args CLIArgs, flags CLIFlags) error {
+	subcommand := "show"
+	if len(args) > 0 {
+		subcommand, args = args[0], args[1:]
+	}
+
+	switch subcommand {
+	case "show":
+		name := HEAD
+		if len(args) > 0 {
+			name = args[0]
+		}
+		ref, err := reflog.refName(name)
+		if err != nil {
+			return err
+		}
+		entries, err := reflog.Read(ref)
+		if err != nil {
+			return err
+		}
+		for i, entry := range entries {
+			fmt.Printf("%s %s@{%d}: %s\n", entry.NewOid[:min(7, len(entry.NewOid))], name, i, entry.Message)
+		}
+		return nil
+	case "expire":
+		expire, ok := flags["expire"].(string)
+		if !ok {
+			expire = REFLOG_EXPIRE_DEFAULT
+		}
+		before, err := parseDate(expire, time.Now())
+		if err != nil {
+			return err
+		}
+
+		refs := []string(args)
+		if len(refs) == 0 {
+			if refs, err = reflog.Refs(); err != nil {
+				return err
+			}
+		}
+		for _, name := range refs {
+			ref, err := reflog.refName(name)
+			if err != nil {
+				return err
+			}
+			removed, err := reflog.Expire(ref, before)
+			if err != nil {
+				return err
+			}
+			if removed > 0 {
+				fmt.Printf("Expired %d entries from %s\n", removed, ref)
+			}
+		}
+		return nil
+	case "delete":
+		if len(args) == 0 {
+			return fmt.Errorf("not enough args, require an entry like HEAD@{1} to delete")
+		}
+		for _, entry := range args {
+			name, selector, ok := reflog.ParseRevision(entry)
+			n, err := strconv.Atoi(selector)
+			if !ok || err != nil {
+				return fmt.Errorf("%s is not a reflog entry", entry)
+			}
+			ref, err := reflog.refName(name)
+			if err != nil {
+				return err
+			}
+			if err = reflog.Delete(ref, n, false); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown reflog subcommand \"%s\"", subcommand)
+	}
+}
+
+func (CLI) Bisect(args CLIArgs, flags CLIFlags) error {
+	subcommand, args := args[0], args[1:]
+
+	switch subcommand {
+	case "start":
+		if err := bisect.Start(); err != nil {
+			return err
+		}
+		// The first revision given is bad and any others are good
+		for i, rev := range args {
+			term := BISECT_TERM_GOOD
+			if i == 0 {
+				term = BISECT_TERM_BAD
+			}
+			if err := bisect.Mark(term, rev); err != nil {
+				return err
+			}
+		}
+	case BISECT_TERM_GOOD, BISECT_TERM_BAD, BISECT_TERM_SKIP:
+		if len(args) == 0 {
+			args = CLIArgs{HEAD}
+		}
+		for _, rev := range args {
+			if err := bisect.Mark(subcommand, rev); err != nil {
+				return err
+			}
+		}
+	case "reset":
+		return bisect.Reset()
+	case "log":
+		log, err := bisect.Log()
+		if err != nil {
+			return err
+		}
+		fmt.Print(log)
+		return nil
+	case "replay":
+		if len(args) == 0 {
+			return fmt.Errorf("replay requires a bisect log file")
+		}
+		log, err := os.ReadFile(args[0])
+		if err != nil {
+			return err
+		}
+		step, err := bisect.Replay(string(log))
+		if err != nil {
+			return err
+		}
+		return printBisectStep(step)
+	case "run":
+		// Commands with flags of their own are given after "--"
+		paths, _ := flags["paths"].(CLIArgs)
+		return bisectRun(slices.Concat(args, paths))
+	default:
+		return fmt.Errorf("unknown bisect subcommand \"%s\"", subcommand)
+	}
+
+	step, err := bisect.Next()
+	if err != nil {
+		return err
+	}
+	return printBisectStep(step)
+}
+
+// bisectRun tests commits with command until the first bad one is found. An exit code of 0 means
+// good, 125 means the commit cannot be tested and any other code up to 127 means bad
+func bisectRun(command []string) error {
+	if len(command) == 0 {
+		return fmt.Errorf("run requires a command")
+	}
+	if !bisect.InProgress() {
+		return fmt.Errorf("not bisecting, run \"gogit bisect start\" first")
+	}
+
+	for {
+		fmt.Printf("running %s\n", strings.Join(command, " "))
+		cmd := exec.Command(command[0], command[1:]...)
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+
+		code := 0
+		if err := cmd.Run(); err != nil {
+			exitErr, ok := err.(*exec.ExitError)
+			if !ok {
+				return err
+			}
+			code = exitErr.ExitCode()
+		}
+
+		var term string
+		switch {
+		case code == 0:
+			term = BISECT_TERM_GOOD
+		case code == 125:
+			term = BISECT_TERM_SKIP
+		case code > 0 && code < 128:
+			term = BISECT_TERM_BAD
+		default:
+			return fmt.Errorf("bisect run failed: exit code %d from %s", code, strings.Join(command, " "))
+		}
+		if err := bisect.Mark(term, HEAD); err != nil {
+			return err
+		}
+
+		step, err := bisect.Next()
+		if err != nil {
+			return err
+		}
+		if err = printBisectStep(step); err != nil {
+			return err
+		}
+		if step.Oid == "" {
+			return nil
+		}
+	}
+}
+
+func printBisectStep(step BisectStep) error {
+	switch {
+	case step.FirstBad != "":
+		fmt.Printf("%s is the first bad commit\n", step.FirstBad)
+		c, err := base.GetCommit(step.FirstBad)
+		if err != nil {
+			return err
+		}
+		base.printCommit(step.FirstBad, *c, nil)
+	case len(step.Skipped) > 0:
+		fmt.Println("There are only 'skip'ped commits left to test.")
+		fmt.Println("The first bad commit could be any of:")
+		for _, oid := range step.Skipped {
+			fmt.Println(oid)
+		}
+	case step.Oid != "":
+		description, err := bisect.describe(step.Oid)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Bisecting: %d revisions left to test after this (roughly %d steps)\n", step.Remaining, step.Steps)
+		fmt.Println(description)
+	default:
+		fmt.Println("status: waiting for both good and bad commits")
+	}
+	return nil
+}
+
+// checkoutMode returns how local changes are handled when the working directory is updated
+func checkoutMode(flags CLIFlags) int {
+	if force, ok := flags["force"].(bool); ok && force {
+		return CHECKOUT_FORCE
+	}
+	if merge, ok := flags["merge"].(bool); ok && merge {
+		return CHECKOUT_MERGE
+	}
+	return CHECKOUT_SAFE
+}
+
+// Matches -M with a threshold attached, as git writes it
+var findRenamesRegex = regexp.MustCompile(`^-M(\d+%?)$`)
+
+// expandFindRenames rewrites the forms of -M the flag package does not understand. Without a threshold
+// it uses the default one, and git's -M60 becomes -M=60. As in git, a threshold given as a separate
+// argument is not part of the flag
+func expandFindRenames(args CLIArgs) CLIArgs {
+	expanded := slices.Clone(args)
+	for i, arg := range expanded {
+		if arg == "-M" || arg == "--find-renames" {
+			expanded[i] = fmt.Sprintf("%s=%d", arg, RENAME_THRESHOLD)
+		} else if match := findRenamesRegex.FindStringSubmatch(arg); match != nil {
+			expanded[i] = "-M=" + match[1]
+		}
+	}
+	return expanded
+}
+
+func parseFlags(fs *flag.FlagSet, flags CLIFlags, args CLIArgs, flagIdx int) (CLIFlags, CLIArgs, error) {
 	if flagIdx < 0 {
 		return CLIFlags{}, args, nil
 	}
 
-	_ = flag.CommandLine.Parse(args[flagIdx:])
+	if err := fs.Parse(args[flagIdx:]); err != nil {
+		return CLIFlags{}, CLIArgs{}, err
+	}
 
 	f := CLIFlags{}
 	for name, value := range flags {
@@ -421,12 +1184,93 @@ func parseFlags(flags CLIFlags, args CLIArgs, flagIdx int) (CLIFlags, CLIArgs, e
 			if *v {
 				f[name] = *v
 			}
+		case *int:
+			if *v != 0 {
+				f[name] = *v
+			}
 		default:
 			return CLIFlags{}, CLIArgs{}, fmt.Errorf("fatal: unknown flag type %T", v)
 		}
 	}
 
-	return f, args[:flagIdx], nil
+	// Positional args may also follow the flags
+	return f, slices.Concat(args[:flagIdx], fs.Args()), nil
+}
+
+// defineFlags registers every flag on fs and returns them by the name commands know them by
+func defineFlags(fs *flag.FlagSet, cmd string) CLIFlags {
+	// --all is a long form of -a
+	all := fs.Bool("a", false, "stage modified and deleted files before committing, or include every ref")
+	fs.BoolVar(all, "all", false, "include every ref")
+
+	// --find-renames is a long form of -M
+	renames := fs.String("M", "", "detect renames of files at least this similar, as a percentage")
+	fs.StringVar(renames, "find-renames", "", "detect renames of files at least this similar, as a percentage")
+
+	// --include-untracked is a long form of -u
+	untracked := fs.Bool("u", false, "also stash untracked files")
+	fs.BoolVar(untracked, "include-untracked", false, "also stash untracked files")
+
+	flags := CLIFlags{
+		"message":           fs.String("m", "", "commit message"),
+		"branch":            fs.String("b", "", "branch name"),
+		"cached":            fs.Bool("cached", false, "diff using index"),
+		"verbose":           fs.Bool("v", false, "verbose output"),
+		"force":             fs.Bool("force", false, "discard local changes"),
+		"merge":             fs.Bool("merge", false, "merge local changes"),
+		"source":            fs.String("source", "", "revision to restore from"),
+		"staged":            fs.Bool("staged", false, "restore the index"),
+		"worktree":          fs.Bool("worktree", false, "restore the working tree"),
+		"stage-all":         fs.Bool("A", false, "stage all changes including deletions"),
+		"recursive":         fs.Bool("r", false, "remove directories recursively"),
+		"soft":              fs.Bool("soft", false, "only move HEAD"),
+		"mixed":             fs.Bool("mixed", false, "move HEAD and reset the index"),
+		"hard":              fs.Bool("hard", false, "move HEAD and reset the index and working tree"),
+		"patch":             fs.Bool("p", false, "interactively select hunks"),
+		"all":               all,
+		"amend":             fs.Bool("amend", false, "replace the tip commit"),
+		"include-untracked": untracked,
+		"keep-index":        fs.Bool("keep-index", false, "leave staged changes in place when stashing"),
+		"expire":            fs.String("expire", "", "prune reflog entries older than this date"),
+		"oneline":           fs.Bool("oneline", false, "print each commit on one line"),
+		"format":            fs.String("format", "", "print commits using placeholders like %h and %s"),
+		"since":             fs.String("since", "", "only show commits after this date"),
+		"until":             fs.String("until", "", "only show commits before this date"),
+		"author":            fs.String("author", "", "only show commits whose author matches this pattern"),
+		"grep":              fs.String("grep", "", "only show commits whose message matches this pattern"),
+		"reverse":           fs.Bool("reverse", false, "show the oldest commits first"),
+		"first-parent":      fs.Bool("first-parent", false, "only follow the first parent of merges"),
+		"merges":            fs.Bool("merges", false, "only show merge commits"),
+		"no-merges":         fs.Bool("no-merges", false, "leave out merge commits"),
+		"graph":             fs.Bool("graph", false, "draw the commit history as lanes"),
+		"date-order":        fs.Bool("date-order", false, "show no parents before all of their children, otherwise by date"),
+		"author-date-order": fs.Bool("author-date-order", false, "as --date-order, using author dates"),
+		"pickaxe":           fs.String("S", "", "only show commits changing the number of occurrences of a string"),
+		"pickaxe-regex":     fs.String("G", "", "only show commits adding or removing lines matching a pattern"),
+		"ignore-case":       fs.Bool("i", false, "match patterns regardless of case"),
+		"extended-regexp":   fs.Bool("E", false, "use extended regular expressions"),
+		"line-range":        fs.String("L", "", "only blame the lines <start>,<end> or <start>,+<count>"),
+		"porcelain":         fs.Bool("porcelain", false, "print blame output for machines"),
+		"topo-order":        fs.Bool("topo-order", false, "show no parents before all of their children, one line of history at a time"),
+		"find-renames":      renames,
+		"find-copies":       fs.Bool("C", false, "detect copies as well as renames"),
+		"no-renames":        fs.Bool("no-renames", false, "do not detect renames"),
+		"follow":            fs.Bool("follow", false, "continue listing the history of a file beyond renames"),
+		"stat":              fs.Bool("stat", false, "summarise the changed lines of each file with a histogram"),
+		"numstat":           fs.Bool("numstat", false, "print the number of added and deleted lines of each file"),
+		"name-only":         fs.Bool("name-only", false, "only print the paths of changed files"),
+		"name-status":       fs.Bool("name-status", false, "only print the paths of changed files and how they changed"),
+		"word-diff":         fs.Bool("word-diff", false, "show changes within lines word by word"),
+		"color":             fs.String("color", "", "color output: auto, always or never"),
+	}
+
+	// -n means something else to grep than it does to log
+	if cmd == "grep" {
+		flags["line-number"] = fs.Bool("n", false, "prefix matches with their line number")
+	} else {
+		flags["max-count"] = fs.Int("n", 0, "limit the number of commits")
+	}
+	return flags
 }
 
 func main() {
@@ -439,6 +1283,20 @@ func main() {
 	}
 
 	cmd, args := input[1], input[2:]
+
+	// --not is not a flag, it changes the meaning of the revisions after it
+	if cmd == "log" || cmd == "rev-list" {
+		args = revision.ExpandNot(args)
+	}
+
+	// Everything after "--" is a path
+	var paths CLIArgs
+	if i := slices.Index(args, "--"); i > -1 {
+		args, paths = args[:i], args[i+1:]
+	}
+
+	args = expandFindRenames(args)
+
 	// Parse args up to first flag
 	firstArgWithDash := -1
 	for i := 0; i < len(args); i++ {
@@ -448,39 +1306,72 @@ func main() {
 		}
 	}
 
-	flags := CLIFlags{
-		"message": flag.String("m", "", "commit message"),
-		"branch":  flag.String("b", "", "branch name"),
-		"cached":  flag.Bool("cached", false, "diff using index"),
-	}
-
-	flags, args, err = parseFlags(flags, args, firstArgWithDash)
+	flags := defineFlags(flag.CommandLine, cmd)
+	flags, args, err = parseFlags(flag.CommandLine, flags, args, firstArgWithDash)
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
+	if paths != nil {
+		flags["paths"] = paths
+	}
+	if color, ok := flags["color"].(string); ok {
+		if !slices.Contains([]string{COLOR_AUTO, COLOR_ALWAYS, COLOR_NEVER}, color) {
+			fmt.Println(GogitError{message: fmt.Sprintf("invalid --color \"%s\", expected auto, always or never", color)})
+			os.Exit(1)
+		}
+		COLOR_OUTPUT = color
+	}
 
 	var none map[string]bool
+	grepFlags := map[string]bool{"line-number": false, "ignore-case": false, "extended-regexp": false, "cached": false, "paths": false}
+	revListFlags := map[string]bool{"first-parent": false, "date-order": false, "author-date-order": false, "topo-order": false}
+	logFlags := map[string]bool{
+		"oneline": false, "format": false, "max-count": false, "since": false, "until": false, "author": false,
+		"grep": false, "reverse": false, "first-parent": false, "merges": false, "no-merges": false, "graph": false,
+		"date-order": false, "author-date-order": false, "topo-order": false, "pickaxe": false, "pickaxe-regex": false,
+		"paths": false, "follow": false, "find-renames": false, "find-copies": false, "no-renames": false,
+	}
+	renameFlags := map[string]bool{"find-renames": false, "find-copies": false, "no-renames": false, "color": false}
+	showFlags := map[string]bool{
+		"find-renames": false, "find-copies": false, "no-renames": false, "stat": false, "numstat": false,
+		"name-only": false, "name-status": false, "word-diff": false, "color": false,
+	}
+	diffFlags := maps.Clone(showFlags)
+	diffFlags["cached"] = false
 	commands := map[string]Command{
-		"init":       {cli.Init, 0, none},
-		"cat-file":   {cli.CatFile, 1, none},
-		"commit":     {cli.Commit, 0, map[string]bool{"message": true}},
-		"log":        {cli.Log, 0, none},
-		"checkout":   {cli.Checkout, 0, map[string]bool{"branch": false}},
-		"tag":        {cli.Tag, 2, none},
-		"k":          {cli.K, 0, none},
-		"branch":     {cli.Branch, 0, none},
-		"status":     {cli.Status, 0, none},
-		"reset":      {cli.Reset, 1, none},
-		"show":       {cli.Show, 1, none},
-		"diff":       {cli.Diff, 0, map[string]bool{"cached": false}},
-		"merge":      {cli.Merge, 1, none},
-		"rebase":     {cli.Rebase, 1, none},
-		"fetch":      {cli.Fetch, 1, none},
-		"push":       {cli.Push, 2, none},
-		"add":        {cli.Add, 1, none},
-		"read-index": {cli.ReadIndex, 0, none},
-		"gc":         {cli.GC, 0, none},
+		"init":         {cli.Init, 0, none},
+		"cat-file":     {cli.CatFile, 1, none},
+		"commit":       {cli.Commit, 0, map[string]bool{"message": false, "all": false, "amend": false}},
+		"log":          {cli.Log, 0, logFlags},
+		"checkout":     {cli.Checkout, 0, map[string]bool{"branch": false, "force": false, "merge": false, "paths": false}},
+		"tag":          {cli.Tag, 2, none},
+		"k":            {cli.K, 0, none},
+		"branch":       {cli.Branch, 0, none},
+		"status":       {cli.Status, 0, renameFlags},
+		"reset":        {cli.Reset, 0, map[string]bool{"soft": false, "mixed": false, "hard": false, "patch": false, "paths": false}},
+		"show":         {cli.Show, 1, showFlags},
+		"diff":         {cli.Diff, 0, diffFlags},
+		"merge":        {cli.Merge, 1, map[string]bool{"force": false, "merge": false}},
+		"rebase":       {cli.Rebase, 1, none},
+		"fetch":        {cli.Fetch, 1, none},
+		"push":         {cli.Push, 2, none},
+		"add":          {cli.Add, 0, map[string]bool{"stage-all": false, "patch": false}},
+		"rm":           {cli.Rm, 1, map[string]bool{"cached": false, "recursive": false, "force": false}},
+		"mv":           {cli.Mv, 2, none},
+		"read-index":   {cli.ReadIndex, 0, none},
+		"rev-parse":    {cli.RevParse, 1, none},
+		"rev-list":     {cli.RevList, 0, revListFlags},
+		"bisect":       {cli.Bisect, 1, map[string]bool{"paths": false}},
+		"grep":         {cli.Grep, 1, grepFlags},
+		"blame":        {cli.Blame, 1, map[string]bool{"line-range": false, "porcelain": false}},
+		"gc":           {cli.GC, 0, none},
+		"pack-refs":    {cli.PackRefs, 0, map[string]bool{"all": false}},
+		"lfs":          {cli.LFS, 1, none},
+		"check-ignore": {cli.CheckIgnore, 1, map[string]bool{"verbose": false}},
+		"stash":        {cli.Stash, 0, map[string]bool{"message": false, "include-untracked": false, "keep-index": false, "patch": false}},
+		"reflog":       {cli.Reflog, 0, map[string]bool{"expire": false}},
+		"restore":      {cli.Restore, 0, map[string]bool{"source": false, "staged": false, "worktree": false, "patch": false, "paths": false}},
 	}
 
 	if fn, ok := commands[cmd]; ok {
@@ -5,13 +5,18 @@ import (
 	"context"
 	"crypto/sha1"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
 	"io/fs"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 )
 
 // If this changes, you must change the "tests" Make target as well
@@ -57,6 +62,11 @@ func expectDirLength(t *testing.T, ctx context.Context, dirPath string, length i
 }
 
 func expectOutput(t *testing.T, ctx context.Context, fn func(), expected string) {
+	expectEquals(t, ctx, captureOutput(fn), expected)
+}
+
+// captureOutput returns what fn writes to stdout
+func captureOutput(fn func()) string {
 	rescueStdout := os.Stdout
 	r, w, _ := os.Pipe()
 	os.Stdout = w
@@ -64,7 +74,7 @@ func expectOutput(t *testing.T, ctx context.Context, fn func(), expected string)
 	w.Close()
 	out, _ := io.ReadAll(r)
 	os.Stdout = rescueStdout
-	expectEquals(t, ctx, string(out), expected)
+	return string(out)
 }
 
 // ** End Test Helpers **
@@ -75,6 +85,7 @@ func setupInit() error {
 
 	initDirs := []string{
 		filepath.Join(GOGIT_ROOT, "objects"),
+		filepath.Join(GOGIT_ROOT, TMP_DIR),
 		filepath.Join(GOGIT_ROOT, "refs", "heads"),
 		filepath.Join(GOGIT_ROOT, "refs", "tags"),
 	}
@@ -101,10 +112,10 @@ func setupCreateFile(path string, content []byte, addToIndex bool) error {
 	// Write file to index and objects
 	if addToIndex {
 		oid := getOid(content, BLOB)
-		content, _ := json.Marshal(map[string]string{path: oid})
+		indexContent, _ := json.Marshal(map[string]string{path: oid})
 		setupCreateObject(oid, []byte(fmt.Sprintf("blob\x00%s", content)))
 
-		if err := os.WriteFile(GOGIT_INDEX, content, FP); err != nil {
+		if err := os.WriteFile(GOGIT_INDEX, indexContent, FP); err != nil {
 			return err
 		}
 	}
@@ -112,7 +123,12 @@ func setupCreateFile(path string, content []byte, addToIndex bool) error {
 	return os.WriteFile(filepath.Join(TEST_DIR, path), content, FP)
 }
 
-func setupCommit(branch string, commitOID string, parentOID string, blobs map[string][]byte) {
+// Real OIDs of the commits made by setupCommit, by the name they were given
+var fixtureOids = map[string]string{}
+
+// setupCommit commits blobs on a branch. The commit is named so tests can refer to it, and its parent is
+// either a name given to an earlier commit or an OID
+func setupCommit(branch string, name string, parent string, blobs map[string][]byte) {
 	// Create blobs
 	blobOIDs := map[string]string{}
 	for path, content := range blobs {
@@ -125,20 +141,26 @@ func setupCommit(branch string, commitOID string, parentOID string, blobs map[st
 
 	// Create tree
 	var treeContent string
-	for path, blobOID := range blobOIDs {
-		treeContent += fmt.Sprintf("%s %s blob\n", path, blobOID)
+	for _, path := range slices.Sorted(maps.Keys(blobOIDs)) {
+		treeContent += fmt.Sprintf("%s %s blob\n", path, blobOIDs[path])
 	}
 
 	treeOID := getOid([]byte(treeContent), TREE)
 	setupCreateObject(treeOID, []byte(fmt.Sprintf("tree\x00%s", treeContent)))
 
 	var parentString string
-	if parentOID != "" {
-		parentString = fmt.Sprintf("\nparent %s", parentOID)
+	if parent != "" {
+		if oid, ok := fixtureOids[parent]; ok {
+			parent = oid
+		}
+		parentString = fmt.Sprintf("\nparent %s", parent)
 	}
 
-	// Create commit
-	setupCreateObject(commitOID, []byte(fmt.Sprintf("commit\x00tree %s\ntime 12:00%s\nmessage XXX", treeOID, parentString)))
+	// Create commit, its message keeps commits with the same tree and parent apart
+	commitContent := fmt.Sprintf("tree %s\ntime 12:00%s\nmessage %s", treeOID, parentString, name)
+	commitOID := getOid([]byte(commitContent), COMMIT)
+	setupCreateObject(commitOID, []byte(fmt.Sprintf("commit\x00%s", commitContent)))
+	fixtureOids[name] = commitOID
 
 	os.MkdirAll(filepath.Join(GOGIT_DIR, "refs", "heads"), FP)
 	os.WriteFile(filepath.Join(GOGIT_DIR, "refs", "heads", branch), []byte(commitOID), FP)
@@ -152,13 +174,12 @@ func setupBranch(name string, branchOff string, numCommits int) {
 	}
 
 	for i := range numCommits {
-		commitOID := fmt.Sprintf("%s-commit-%d", name, i+1)
-		setupCommit(name, commitOID, parent, map[string][]byte{
+		setupCommit(name, fmt.Sprintf("%s-commit-%d", name, i+1), parent, map[string][]byte{
 			"test-1.txt": []byte(fmt.Sprintf("Testing branch \"%s\" commit %d!", name, i+1)),
 			"test-2.txt": []byte(fmt.Sprintf("Testing branch \"%s\" commit %d!", name, i+1)),
 			"test-3.txt": []byte(fmt.Sprintf("Testing branch \"%s\" commit %d!", name, i+1)),
 		})
-		parent = commitOID
+		parent = fixtureOids[fmt.Sprintf("%s-commit-%d", name, i+1)]
 	}
 
 	os.MkdirAll(filepath.Join(GOGIT_DIR, "refs", "heads"), FP)
@@ -167,12 +188,32 @@ func setupBranch(name string, branchOff string, numCommits int) {
 	setHEAD("main")
 }
 
+// parseCLI parses the args of cmd the way main does
+func parseCLI(cmd string, args CLIArgs) (CLIArgs, CLIFlags, error) {
+	fs := flag.NewFlagSet("gogit", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	flagIdx := slices.IndexFunc(args, func(arg string) bool { return strings.HasPrefix(arg, "-") })
+	flags, args, err := parseFlags(fs, defineFlags(fs, cmd), args, flagIdx)
+	return args, flags, err
+}
+
 func inspectRef(ref string) string {
 	buf, err := os.ReadFile(filepath.Join(GOGIT_DIR, ref))
+	if err == nil {
+		return string(buf)
+	}
+
+	// Fall back to the packed refs file
+	packed, err := os.ReadFile(filepath.Join(GOGIT_DIR, PACKED_REFS_FILE))
 	if err != nil {
 		return ""
 	}
-	return string(buf)
+	for _, line := range strings.Split(string(packed), "\n") {
+		if oid, name, ok := strings.Cut(line, " "); ok && name == ref {
+			return oid
+		}
+	}
+	return ""
 }
 
 func inspectIndex() map[string]string {
@@ -196,8 +237,35 @@ func inspectFile(filePath string) []byte {
 	return content
 }
 
+// setHEAD points HEAD at a branch and, as a checkout would, makes the index and working files match
+// the branch's commit
 func setHEAD(refName string) error {
-	return os.WriteFile(filepath.Join(GOGIT_DIR, HEAD), []byte(fmt.Sprintf("ref: refs/heads/%s", refName)), FP)
+	if err := os.WriteFile(filepath.Join(GOGIT_DIR, HEAD), []byte(fmt.Sprintf("ref: refs/heads/%s", refName)), FP); err != nil {
+		return err
+	}
+	oid := inspectRef(fmt.Sprintf("refs/heads/%s", refName))
+	if oid == "" {
+		return nil
+	}
+	c, err := base.GetCommit(oid)
+	if err != nil {
+		return err
+	}
+	tree, err := base.GetTree(c.TreeOid, "")
+	if err != nil {
+		return err
+	}
+	for path, oid := range tree {
+		content, _, err := data.GetObject(oid)
+		if err != nil {
+			return err
+		}
+		if err := setupCreateFile(path, content, false); err != nil {
+			return err
+		}
+	}
+	indexContent, _ := json.Marshal(tree)
+	return os.WriteFile(GOGIT_INDEX, indexContent, FP)
 }
 
 func getOid(data []byte, _type string) string {
@@ -283,7 +351,7 @@ func Test_CLI(t *testing.T) {
 		{
 			Name:  "Checkout - Existing Branch",
 			Args:  CLIArgs{"existing-branch"},
-			Flags: CLIFlags{},
+			Flags: CLIFlags{"force": true},
 			Setup: func() {
 				setupInit()
 				setupBranch("existing-branch", "main", 1)
@@ -307,9 +375,115 @@ func Test_CLI(t *testing.T) {
 				expectEquals(t, ctx, inspectRef(HEAD), "ref: refs/heads/existing-branch")
 			},
 		},
+		{
+			Name:  "Checkout - Refuses To Overwrite Local Changes",
+			Args:  CLIArgs{"other"},
+			Flags: CLIFlags{},
+			Setup: func() {
+				setupInit()
+				setupCommit("main", "main-commit-1", "", map[string][]byte{"test.txt": []byte("a\nb\nc\n")})
+				setupCommit("other", "other-commit-1", "main-commit-1", map[string][]byte{"test.txt": []byte("A\nb\nc\n")})
+				setHEAD("main")
+				setupCreateFile("test.txt", []byte("a\nb\nc\n"), true)
+				setupCreateFile("untracked.txt", []byte("Untracked"), false)
+			},
+			Cleanup: func() {
+				cleanup(t, nil)
+			},
+			Run: func(args CLIArgs, flags CLIFlags) {
+				ctx := context.WithValue(context.Background(), TestName, "Checkout - Refuses To Overwrite Local Changes")
+				os.WriteFile(filepath.Join(TEST_DIR, "test.txt"), []byte("a\nb\nC\n"), FP)
+
+				err := cli.Checkout(args, flags)
+				if _, ok := err.(CheckoutConflictError); !ok {
+					cleanup(t, fmt.Errorf("expected checkout conflict, received: %v", err))
+				}
+				expectEquals(t, ctx, string(inspectFile("test.txt")), "a\nb\nC\n")
+				expectEquals(t, ctx, inspectRef(HEAD), "ref: refs/heads/main")
+
+				// Local changes are merged into the target content
+				if err := cli.Checkout(args, CLIFlags{"merge": true}); err != nil {
+					cleanup(t, err)
+				}
+				expectEquals(t, ctx, string(inspectFile("test.txt")), "A\nb\nC\n")
+				expectEquals(t, ctx, string(inspectFile("untracked.txt")), "Untracked")
+				expectEquals(t, ctx, inspectRef(HEAD), "ref: refs/heads/other")
+			},
+		},
+		{
+			Name:  "Checkout - Refuses To Overwrite Staged Changes",
+			Args:  CLIArgs{"other"},
+			Flags: CLIFlags{},
+			Setup: func() {
+				setupInit()
+				setupCommit("main", "main-commit-1", "", map[string][]byte{"test.txt": []byte("a\nb\nc\n")})
+				setupCommit("other", "other-commit-1", "main-commit-1", map[string][]byte{"test.txt": []byte("A\nb\nc\n")})
+				setHEAD("main")
+				setupCreateFile("test.txt", []byte("a\nb\nC\n"), true)
+			},
+			Cleanup: func() {
+				cleanup(t, nil)
+			},
+			Run: func(args CLIArgs, flags CLIFlags) {
+				ctx := context.WithValue(context.Background(), TestName, "Checkout - Refuses To Overwrite Staged Changes")
+				// The working file matches the index, so only the staged change is at risk
+				staged := inspectIndex()["test.txt"]
+
+				err := cli.Checkout(args, flags)
+				if _, ok := err.(CheckoutConflictError); !ok {
+					cleanup(t, fmt.Errorf("expected checkout conflict, received: %v", err))
+				}
+				expectEquals(t, ctx, inspectIndex()["test.txt"], staged)
+				expectEquals(t, ctx, inspectRef(HEAD), "ref: refs/heads/main")
+
+				err = cli.Merge(args, CLIFlags{"merge": true})
+				if _, ok := err.(CheckoutConflictError); !ok {
+					cleanup(t, fmt.Errorf("expected merge conflict, received: %v", err))
+				}
+				expectEquals(t, ctx, inspectIndex()["test.txt"], staged)
+
+				if err := cli.Checkout(args, CLIFlags{"force": true}); err != nil {
+					cleanup(t, err)
+				}
+				expectEquals(t, ctx, string(inspectFile("test.txt")), "A\nb\nc\n")
+				expectEquals(t, ctx, inspectRef(HEAD), "ref: refs/heads/other")
+			},
+		},
+		{
+			Name:  "Restore - Staged and Worktree",
+			Args:  CLIArgs{"test.txt"},
+			Flags: CLIFlags{"staged": true},
+			Setup: func() {
+				setupInit()
+				setupCommit("main", "main-commit-1", "", map[string][]byte{"test.txt": []byte("Hello World!")})
+				setupCreateFile("test.txt", []byte("Goodbye World!"), true)
+				setupCreateFile("other.txt", []byte("Untouched"), false)
+			},
+			Cleanup: func() {
+				cleanup(t, nil)
+			},
+			Run: func(args CLIArgs, flags CLIFlags) {
+				ctx := context.WithValue(context.Background(), TestName, "Restore - Staged and Worktree")
+
+				// Unstage the change, leaving the working tree alone
+				if err := cli.Restore(args, flags); err != nil {
+					cleanup(t, err)
+				}
+				expectEquals(t, ctx, inspectIndex()["test.txt"], getOid([]byte("Hello World!"), BLOB))
+				expectEquals(t, ctx, string(inspectFile("test.txt")), "Goodbye World!")
+
+				// Discard the change in the working tree from the index
+				if err := cli.Restore(args, CLIFlags{}); err != nil {
+					cleanup(t, err)
+				}
+				expectEquals(t, ctx, string(inspectFile("test.txt")), "Hello World!")
+				expectEquals(t, ctx, string(inspectFile("other.txt")), "Untouched")
+				expectEquals(t, ctx, inspectRef("refs/heads/main"), fixtureOids["main-commit-1"])
+			},
+		},
 		{
 			Name:  "Tag",
-			Args:  CLIArgs{"new-tag", "commit-1"},
+			Args:  CLIArgs{"new-tag", "main"},
 			Flags: CLIFlags{},
 			Setup: func() {
 				setupInit()
@@ -325,7 +499,7 @@ func Test_CLI(t *testing.T) {
 				}
 
 				expectExists(t, ctx, filepath.Join(GOGIT_DIR, "refs", "tags"), true)
-				expectEquals(t, ctx, inspectRef("refs/tags/new-tag"), "commit-1")
+				expectEquals(t, ctx, inspectRef("refs/tags/new-tag"), fixtureOids["commit-1"])
 			},
 		},
 		{
@@ -365,7 +539,7 @@ func Test_CLI(t *testing.T) {
 
 				expectEquals(t, ctx, inspectRef(HEAD), "ref: refs/heads/main")
 				expectExists(t, ctx, filepath.Join(GOGIT_DIR, "refs", "heads", "new-branch"), true)
-				expectEquals(t, ctx, inspectRef("refs/heads/new-branch"), "commit-1")
+				expectEquals(t, ctx, inspectRef("refs/heads/new-branch"), fixtureOids["commit-1"])
 			},
 		},
 		{
@@ -415,167 +589,1534 @@ func Test_CLI(t *testing.T) {
 			},
 		},
 		{
-			Name:  "Commit",
+			Name:  "Add - Streamed Compressed File",
+			Args:  CLIArgs{"large.bin"},
+			Flags: CLIFlags{},
+			Setup: func() {
+				setupInit()
+				COMPRESS_OBJECTS = true
+				setupCreateFile("large.bin", []byte(strings.Repeat("0123456789abcdef", 1<<16)), false)
+			},
+			Cleanup: func() {
+				COMPRESS_OBJECTS = false
+				cleanup(t, nil)
+			},
+			Run: func(args CLIArgs, flags CLIFlags) {
+				ctx := context.WithValue(context.Background(), TestName, "Add - Streamed Compressed File")
+				if err := cli.Add(args, flags); err != nil {
+					cleanup(t, err)
+				}
+
+				content := strings.Repeat("0123456789abcdef", 1<<16)
+				oid := inspectIndex()["large.bin"]
+				expectEquals(t, ctx, oid, getOid([]byte(content), BLOB))
+				// Only the compressed object should remain in the store
+				expectDirLength(t, ctx, filepath.Join(GOGIT_DIR, "objects"), 1)
+				expectDirLength(t, ctx, filepath.Join(GOGIT_DIR, TMP_DIR), 0)
+				// Objects get the mode any other file gets under the umask
+				os.WriteFile("probe", nil, FP)
+				probe, err := os.Stat("probe")
+				if err != nil {
+					cleanup(t, err)
+				}
+				info, err := os.Stat(filepath.Join(GOGIT_DIR, "objects", oid))
+				if err != nil {
+					cleanup(t, err)
+				}
+				expectEquals(t, ctx, info.Mode().Perm(), probe.Mode().Perm())
+
+				buf, _type, err := data.GetObject(oid)
+				if err != nil {
+					cleanup(t, err)
+				}
+				expectEquals(t, ctx, _type, BLOB)
+				expectEquals(t, ctx, string(buf), content)
+			},
+		},
+		{
+			Name:  "Add - Stage All Including Deletions",
 			Args:  CLIArgs{},
-			Flags: CLIFlags{"message": "first commit"},
+			Flags: CLIFlags{"stage-all": true},
 			Setup: func() {
 				setupInit()
-				setupCreateFile("test.txt", []byte("Hello World!"), true)
+				setupCreateFile("deleted.txt", []byte("Deleted"), true)
+				setupCreateFile("new.txt", []byte("New"), false)
+				os.Remove(filepath.Join(TEST_DIR, "deleted.txt"))
 			},
 			Cleanup: func() {
 				cleanup(t, nil)
 			},
 			Run: func(args CLIArgs, flags CLIFlags) {
-				ctx := context.WithValue(context.Background(), TestName, "Commit")
-				if err := cli.Commit(args, flags); err != nil {
+				ctx := context.WithValue(context.Background(), TestName, "Add - Stage All Including Deletions")
+				if err := cli.Add(args, flags); err != nil {
 					cleanup(t, err)
 				}
 
-				expectExists(t, ctx, filepath.Join(GOGIT_DIR, HEAD), true)
-				expectEquals(t, ctx, inspectRef(HEAD), "ref: refs/heads/main")
-				expectDirLength(t, ctx, filepath.Join(GOGIT_DIR, "objects"), 3) // commit, tree, blob
+				index := inspectIndex()
+				expectEquals(t, ctx, len(index), 1)
+				expectEquals(t, ctx, index["new.txt"], getOid([]byte("New"), BLOB))
 			},
 		},
 		{
-			Name:  "Merge and Commit",
-			Args:  CLIArgs{"main"},
-			Flags: CLIFlags{},
+			Name:  "Add - Patch",
+			Args:  CLIArgs{"test.txt"},
+			Flags: CLIFlags{"patch": true},
 			Setup: func() {
 				setupInit()
-				setupCommit("main", "main-commit-1", "", map[string][]byte{"test-1.txt": []byte("Hello World!")})
-				// Create branch off main with 2 commits
-				setupBranch("first-branch", "main", 2)
-				// Make another commit on main
-				setupCommit("main", "main-commit-2", "main-commit-1", map[string][]byte{"test-1.txt": []byte("Hello World Again!")})
-				// set HEAD to new branch
-				setHEAD("first-branch")
+				setupCreateFile("test.txt", []byte("1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n"), true)
+				os.WriteFile(filepath.Join(TEST_DIR, "test.txt"), []byte("one\n2\n3\n4\n5\n6\n7\n8\n9\nten\n"), FP)
 			},
 			Cleanup: func() {
 				cleanup(t, nil)
 			},
 			Run: func(args CLIArgs, flags CLIFlags) {
-				ctx := context.WithValue(context.Background(), TestName, "Merge and Commit")
-				if err := cli.Merge(args, flags); err != nil {
+				ctx := context.WithValue(context.Background(), TestName, "Add - Patch")
+
+				// Stage the first hunk and skip the second
+				rescueStdin := os.Stdin
+				r, w, _ := os.Pipe()
+				w.WriteString("y\nn\n")
+				w.Close()
+				os.Stdin = r
+				err := cli.Add(args, flags)
+				os.Stdin = rescueStdin
+				if err != nil {
 					cleanup(t, err)
 				}
 
-				expectExists(t, ctx, filepath.Join(GOGIT_DIR, MERGE_HEAD), true)
-				expectEquals(t, ctx, inspectRef(MERGE_HEAD), "main-commit-2")
-				expectEquals(t, ctx, inspectRef(HEAD), "ref: refs/heads/first-branch")
+				expectEquals(t, ctx, inspectIndex()["test.txt"], getOid([]byte("one\n2\n3\n4\n5\n6\n7\n8\n9\n10\n"), BLOB))
+				expectEquals(t, ctx, string(inspectFile("test.txt")), "one\n2\n3\n4\n5\n6\n7\n8\n9\nten\n")
+
+				// Split a hunk starting with an addition, stage the addition and skip the rest
+				setupCreateFile("test.txt", []byte("a\nb\nc\n"), true)
+				os.WriteFile(filepath.Join(TEST_DIR, "test.txt"), []byte("new\na\nb\nX\nc\n"), FP)
+				r, w, _ = os.Pipe()
+				w.WriteString("s\ny\nn\n")
+				w.Close()
+				os.Stdin = r
+				err = cli.Add(args, flags)
+				os.Stdin = rescueStdin
+				if err != nil {
+					cleanup(t, err)
+				}
 
-				if err := cli.Commit(CLIArgs{}, CLIFlags{"message": "merge commit"}); err != nil {
+				expectEquals(t, ctx, inspectIndex()["test.txt"], getOid([]byte("new\na\nb\nc\n"), BLOB))
+			},
+		},
+		{
+			Name:  "Diff - Cached",
+			Args:  CLIArgs{},
+			Flags: CLIFlags{"cached": true},
+			Setup: func() {
+				setupInit()
+				setupCreateFile("test.txt", []byte("committed\n"), false)
+				cli.Add(CLIArgs{"test.txt"}, CLIFlags{})
+				cli.Commit(CLIArgs{}, CLIFlags{"message": "add"})
+				setupCreateFile("test.txt", []byte("staged\n"), false)
+				cli.Add(CLIArgs{"test.txt"}, CLIFlags{})
+			},
+			Cleanup: func() {
+				cleanup(t, nil)
+			},
+			Run: func(args CLIArgs, flags CLIFlags) {
+				ctx := context.WithValue(context.Background(), TestName, "Diff - Cached")
+				out := captureOutput(func() {
+					if err := cli.Diff(args, flags); err != nil {
+						cleanup(t, err)
+					}
+				})
+
+				// The index is compared against the tree of HEAD
+				expectEquals(t, ctx, strings.Contains(out, "-committed\n"), true)
+				expectEquals(t, ctx, strings.Contains(out, "+staged\n"), true)
+			},
+		},
+		{
+			Name:  "Rm - Recursive and Cached",
+			Args:  CLIArgs{"subdir"},
+			Flags: CLIFlags{"recursive": true},
+			Setup: func() {
+				setupInit()
+				setupCreateFile("subdir/test.txt", []byte("Hello World!"), false)
+				setupCreateFile("kept.txt", []byte("Kept"), false)
+				base.Add("subdir", "kept.txt")
+			},
+			Cleanup: func() {
+				cleanup(t, nil)
+			},
+			Run: func(args CLIArgs, flags CLIFlags) {
+				ctx := context.WithValue(context.Background(), TestName, "Rm - Recursive and Cached")
+				if err := cli.Rm(args, CLIFlags{}); err == nil {
+					cleanup(t, fmt.Errorf("expected rm of a directory without -r to fail"))
+				}
+
+				if err := cli.Rm(args, flags); err != nil {
 					cleanup(t, err)
 				}
+				expectExists(t, ctx, "subdir", false)
+				expectEquals(t, ctx, inspectIndex()["subdir/test.txt"], "")
 
-				expectExists(t, ctx, filepath.Join(GOGIT_DIR, MERGE_HEAD), false)
-				commit := inspectFile(filepath.Join(GOGIT_DIR, "objects", inspectRef("refs/heads/first-branch")))
-				expectEquals(t, ctx, strings.Count(string(commit), "parent"), 2)
+				if err := cli.Rm(CLIArgs{"kept.txt"}, CLIFlags{"cached": true}); err != nil {
+					cleanup(t, err)
+				}
+				expectExists(t, ctx, "kept.txt", true)
+				expectEquals(t, ctx, len(inspectIndex()), 0)
 			},
 		},
 		{
-			Name:  "Merge - Fast Forward",
-			Args:  CLIArgs{"main"},
+			Name:  "Mv",
+			Args:  CLIArgs{"test.txt", "subdir"},
 			Flags: CLIFlags{},
 			Setup: func() {
 				setupInit()
-				setupCommit("main", "main-commit-1", "", map[string][]byte{"test-1.txt": []byte("Hello World!")})
-				// Create branch off main with 0 commits
-				setupBranch("first-branch", "main", 0)
-				// Make another commit on main
-				setupCommit("main", "main-commit-2", "main-commit-1", map[string][]byte{"test-1.txt": []byte("Hello World Again!")})
-				// set HEAD to new branch
-				setHEAD("first-branch")
+				setupCreateFile("test.txt", []byte("Hello World!"), true)
+				os.MkdirAll(filepath.Join(TEST_DIR, "subdir"), FP)
 			},
 			Cleanup: func() {
 				cleanup(t, nil)
 			},
 			Run: func(args CLIArgs, flags CLIFlags) {
-				ctx := context.WithValue(context.Background(), TestName, "Merge - Fast Forward")
-				if err := cli.Merge(args, flags); err != nil {
+				ctx := context.WithValue(context.Background(), TestName, "Mv")
+				if err := cli.Mv(args, flags); err != nil {
 					cleanup(t, err)
 				}
 
-				expectExists(t, ctx, filepath.Join(GOGIT_DIR, MERGE_HEAD), false)
-				expectEquals(t, ctx, inspectRef(HEAD), "ref: refs/heads/first-branch")
-				expectEquals(t, ctx, inspectRef("refs/heads/first-branch"), "main-commit-2")
+				expectExists(t, ctx, "test.txt", false)
+				expectEquals(t, ctx, string(inspectFile("subdir/test.txt")), "Hello World!")
+				expectEquals(t, ctx, inspectIndex()["subdir/test.txt"], getOid([]byte("Hello World!"), BLOB))
+				expectEquals(t, ctx, len(inspectIndex()), 1)
 			},
 		},
 		{
-			Name:  "Rebase",
-			Args:  CLIArgs{"main"},
+			Name:  "LFS - Add and Checkout Large File",
+			Args:  CLIArgs{"."},
 			Flags: CLIFlags{},
 			Setup: func() {
 				setupInit()
-				setupCommit("main", "main-commit-1", "", map[string][]byte{"test-1.txt": []byte("Hello World!")})
-				// Create branch off main with 2 commits
-				setupBranch("first-branch", "main", 2)
-				// Make another commit on main
-				setupCommit("main", "main-commit-2", "main-commit-1", map[string][]byte{"test-1.txt": []byte("Hello World Again!")})
-				// set HEAD to new branch
-				setHEAD("first-branch")
+				setupCreateFile(LFS_PATTERNS_FILE, []byte("*.bin\n"), false)
+				setupCreateFile("assets/model.bin", []byte("Very large binary content"), false)
 			},
 			Cleanup: func() {
 				cleanup(t, nil)
 			},
 			Run: func(args CLIArgs, flags CLIFlags) {
-				ctx := context.WithValue(context.Background(), TestName, "Rebase")
-				if err := cli.Rebase(args, flags); err != nil {
+				ctx := context.WithValue(context.Background(), TestName, "LFS - Add and Checkout Large File")
+				if err := cli.Add(args, flags); err != nil {
 					cleanup(t, err)
 				}
 
-				expectEquals(t, ctx, inspectRef(HEAD), "ref: refs/heads/first-branch")
-
-				// Rebase applies new commits so the commit ids of branch "first-branch" should be different
-				currRef := inspectRef("refs/heads/first-branch")
-				expectNotEquals(t, ctx, currRef, "first-branch-commit-2")
-
-				commit, _ := base.GetCommit(currRef)
-				currRef = commit.ParentOids[0]
-				expectNotEquals(t, ctx, currRef, "first-branch-commit-1")
+				content := "Very large binary content"
+				lfsOid := fmt.Sprintf("%x", sha1.Sum([]byte(content)))
+				pointer := fmt.Sprintf("%s\noid %s\nsize %d\n", LFS_POINTER_HEADER, lfsOid, len(content))
 
-				commit, _ = base.GetCommit(currRef)
-				currRef = commit.ParentOids[0]
-				expectEquals(t, ctx, currRef, "main-commit-2")
+				// The index references the pointer while the content lives in the large file store
+				expectEquals(t, ctx, inspectIndex()["assets/model.bin"], getOid([]byte(pointer), BLOB))
+				expectEquals(t, ctx, string(inspectFile(filepath.Join(GOGIT_DIR, "lfs", "objects", lfsOid))), content)
 
-				commit, _ = base.GetCommit(currRef)
-				currRef = commit.ParentOids[0]
-				expectEquals(t, ctx, currRef, "main-commit-1")
+				if err := os.Remove("assets/model.bin"); err != nil {
+					cleanup(t, err)
+				}
+				if err := base.checkoutIndex(map[string]string{}, inspectIndex(), CHECKOUT_SAFE); err != nil {
+					cleanup(t, err)
+				}
+				expectEquals(t, ctx, string(inspectFile("assets/model.bin")), content)
 			},
 		},
 		{
-			Name:  "GC",
-			Args:  CLIArgs{},
+			Name:  "LFS - Push and Fetch Large File",
+			Args:  CLIArgs{"remote", "main"},
 			Flags: CLIFlags{},
 			Setup: func() {
 				setupInit()
+				setupCreateFile(LFS_PATTERNS_FILE, []byte("*.bin\n"), false)
+				setupCreateFile("assets/model.bin", []byte("Very large binary content"), false)
+				base.Add(".")
+				base.Commit("add model", time.Now())
 
-				setupCommit("new-branch-1", "new-branch-1-commit-1", "", map[string][]byte{
-					"test-branch-1.txt": []byte("Hello World 1!"),
-				})
+				os.MkdirAll("remote", FP)
+				data.ChangeRootDir(filepath.Join("remote", GOGIT_DIR), data.Init)
+			},
+			Cleanup: func() {
+				os.Chdir(TEST_DIR)
+				cleanup(t, nil)
+			},
+			Run: func(args CLIArgs, flags CLIFlags) {
+				ctx := context.WithValue(context.Background(), TestName, "LFS - Push and Fetch Large File")
+				content := "Very large binary content"
+				lfsOid := fmt.Sprintf("%x", sha1.Sum([]byte(content)))
 
-				setupCommit("new-branch-2", "new-branch-2-commit-1", "", map[string][]byte{
-					"test-branch-2.txt": []byte("Hello World 2!"),
-				})
+				// The content is pushed along with its pointer
+				if err := cli.Push(args, flags); err != nil {
+					cleanup(t, err)
+				}
+				expectEquals(t, ctx, string(inspectFile(filepath.Join("remote", GOGIT_DIR, "lfs", "objects", lfsOid))), content)
 
-				// Remove new-branch-1 ref (commit, tree, and blob are now unreachable)
-				if err := os.Remove(filepath.Join(GOGIT_DIR, "refs", "heads", "new-branch-1")); err != nil {
+				os.MkdirAll("clone", FP)
+				os.Chdir("clone")
+				if err := data.Init(); err != nil {
+					cleanup(t, err)
+				}
+				if err := cli.Fetch(CLIArgs{filepath.Join("..", "remote")}, CLIFlags{}); err != nil {
+					cleanup(t, err)
+				}
+				// Fetching leaves the content on the remote until it is checked out
+				expectExists(t, ctx, filepath.Join("clone", GOGIT_DIR, "lfs", "objects", lfsOid), false)
+
+				if err := cli.Checkout(CLIArgs{remoteRefDir + "/main"}, CLIFlags{}); err != nil {
 					cleanup(t, err)
 				}
+				expectEquals(t, ctx, string(inspectFile(filepath.Join("clone", "assets", "model.bin"))), content)
+				expectEquals(t, ctx, string(inspectFile(filepath.Join("clone", GOGIT_DIR, "lfs", "objects", lfsOid))), content)
+			},
+		},
+		{
+			Name:  "Check Ignore - Verbose",
+			Args:  CLIArgs{"debug.log", "keep.log", "data.txt", "build/out.o", "sub/local.txt", "local.txt"},
+			Flags: CLIFlags{"verbose": true},
+			Setup: func() {
+				setupInit()
+				setupCreateFile(IGNORE_FILE, []byte("# logs\n*.log\n!keep.log\nbuild/\n"), false)
+				setupCreateFile("sub/.gogitignore", []byte("local.txt\n"), false)
+				setupCreateFile("build/out.o", []byte(""), false)
+				setupCreateFile("sub/local.txt", []byte(""), false)
 			},
 			Cleanup: func() {
 				cleanup(t, nil)
 			},
 			Run: func(args CLIArgs, flags CLIFlags) {
-				ctx := context.WithValue(context.Background(), TestName, "GC")
-				if err := cli.GC(args, flags); err != nil {
+				ctx := context.WithValue(context.Background(), TestName, "Check Ignore - Verbose")
+				expectOutput(t, ctx, func() {
+					cli.CheckIgnore(args, flags)
+				}, ".gogitignore:2:*.log\tdebug.log\n"+
+					".gogitignore:3:!keep.log\tkeep.log\n"+
+					".gogitignore:4:build/\tbuild/out.o\n"+
+					"sub/.gogitignore:1:local.txt\tsub/local.txt\n")
+
+				expectEquals(t, ctx, data.isIgnored("keep.log"), false)
+				expectEquals(t, ctx, data.isIgnored("data.txt"), false)
+			},
+		},
+		{
+			Name:  "Commit",
+			Args:  CLIArgs{},
+			Flags: CLIFlags{"message": "first commit"},
+			Setup: func() {
+				setupInit()
+				setupCreateFile("test.txt", []byte("Hello World!"), true)
+			},
+			Cleanup: func() {
+				cleanup(t, nil)
+			},
+			Run: func(args CLIArgs, flags CLIFlags) {
+				ctx := context.WithValue(context.Background(), TestName, "Commit")
+				if err := cli.Commit(args, flags); err != nil {
 					cleanup(t, err)
 				}
 
-				// commit, tree, and test.txt blob from new-branch-2 should not be deleted
-				expectDirLength(t, ctx, filepath.Join(GOGIT_DIR, "objects"), 3)
-				oid := inspectRef("refs/heads/new-branch-2")
-				expectNotEquals(t, ctx, oid, "")
-				expectExists(t, ctx, filepath.Join(GOGIT_DIR, "objects", oid), true)
+				expectExists(t, ctx, filepath.Join(GOGIT_DIR, HEAD), true)
+				expectEquals(t, ctx, inspectRef(HEAD), "ref: refs/heads/main")
+				expectDirLength(t, ctx, filepath.Join(GOGIT_DIR, "objects"), 3) // commit, tree, blob
+			},
+		},
+		{
+			Name:  "Commit - All and Amend",
+			Args:  CLIArgs{},
+			Flags: CLIFlags{"message": "second commit", "all": true},
+			Setup: func() {
+				setupInit()
+				setupCreateFile("test.txt", []byte("Hello World!"), false)
+				setupCreateFile("deleted.txt", []byte("Deleted"), false)
+				base.Add("test.txt", "deleted.txt")
+				base.Commit("first commit", time.Now())
+
+				setupCreateFile("test.txt", []byte("Goodbye World!"), false)
+				setupCreateFile("untracked.txt", []byte("Untracked"), false)
+				os.Remove(filepath.Join(TEST_DIR, "deleted.txt"))
+			},
+			Cleanup: func() {
+				cleanup(t, nil)
+			},
+			Run: func(args CLIArgs, flags CLIFlags) {
+				ctx := context.WithValue(context.Background(), TestName, "Commit - All and Amend")
+				firstOid := inspectRef("refs/heads/main")
+				if err := cli.Commit(args, flags); err != nil {
+					cleanup(t, err)
+				}
+
+				// Modified and deleted tracked files are staged, untracked files are not
+				index := inspectIndex()
+				expectEquals(t, ctx, len(index), 1)
+				expectEquals(t, ctx, index["test.txt"], getOid([]byte("Goodbye World!"), BLOB))
+
+				setupCreateFile("test.txt", []byte("Goodbye World Again!"), false)
+				if err := cli.Commit(args, CLIFlags{"all": true, "amend": true}); err != nil {
+					cleanup(t, err)
+				}
+
+				commit, err := base.GetCommit(inspectRef("refs/heads/main"))
+				if err != nil {
+					cleanup(t, err)
+				}
+				expectEquals(t, ctx, commit.Message, "second commit")
+				expectEquals(t, ctx, len(commit.ParentOids), 1)
+				expectEquals(t, ctx, commit.ParentOids[0], firstOid)
+
+				tree, err := base.GetTree(commit.TreeOid, "")
+				if err != nil {
+					cleanup(t, err)
+				}
+				expectEquals(t, ctx, tree["test.txt"], getOid([]byte("Goodbye World Again!"), BLOB))
+			},
+		},
+		{
+			Name:  "Stash - Include Untracked Flag",
+			Args:  CLIArgs{"push", "--include-untracked"},
+			Flags: CLIFlags{},
+			Setup: func() {
+				setupInit()
+				setupCreateFile("test.txt", []byte("Hello World!"), false)
+				base.Add("test.txt")
+				base.Commit("first commit", time.Now())
+				setupCreateFile("untracked.txt", []byte("Untracked"), false)
+			},
+			Cleanup: func() {
+				cleanup(t, nil)
+			},
+			Run: func(args CLIArgs, _ CLIFlags) {
+				ctx := context.WithValue(context.Background(), TestName, "Stash - Include Untracked Flag")
+				// The long flag is the same as -u
+				for _, input := range []CLIArgs{args, {"push", "-u"}} {
+					parsedArgs, flags, err := parseCLI("stash", input)
+					if err != nil {
+						cleanup(t, err)
+					}
+					expectEquals(t, ctx, strings.Join(parsedArgs, " "), "push")
+					expectEquals(t, ctx, flags["include-untracked"], any(true))
+				}
+
+				parsedArgs, flags, _ := parseCLI("stash", args)
+				if err := cli.Stash(parsedArgs, flags); err != nil {
+					cleanup(t, err)
+				}
+				expectExists(t, ctx, "untracked.txt", false)
+			},
+		},
+		{
+			Name:  "Stash - Push and Pop",
+			Args:  CLIArgs{"push"},
+			Flags: CLIFlags{"include-untracked": true},
+			Setup: func() {
+				setupInit()
+				setupCreateFile("test.txt", []byte("Hello World!"), false)
+				base.Add("test.txt")
+				base.Commit("first commit", time.Now())
+
+				setupCreateFile("test.txt", []byte("Goodbye World!"), false)
+				setupCreateFile("staged.txt", []byte("Staged"), false)
+				base.Add("staged.txt")
+				setupCreateFile("untracked.txt", []byte("Untracked"), false)
+			},
+			Cleanup: func() {
+				cleanup(t, nil)
+			},
+			Run: func(args CLIArgs, flags CLIFlags) {
+				ctx := context.WithValue(context.Background(), TestName, "Stash - Push and Pop")
+				if err := cli.Stash(args, flags); err != nil {
+					cleanup(t, err)
+				}
+
+				// The working directory and index are back at HEAD
+				expectEquals(t, ctx, string(inspectFile("test.txt")), "Hello World!")
+				expectExists(t, ctx, "staged.txt", false)
+				expectExists(t, ctx, "untracked.txt", false)
+				expectEquals(t, ctx, len(inspectIndex()), 1)
+
+				commit, err := base.GetCommit(inspectRef(STASH_REF))
+				if err != nil {
+					cleanup(t, err)
+				}
+				expectEquals(t, ctx, len(commit.ParentOids), 3)
+
+				// Stashed objects survive garbage collection
+				if _, err := base.GC(); err != nil {
+					cleanup(t, err)
+				}
+
+				if err := cli.Stash(CLIArgs{"pop"}, CLIFlags{}); err != nil {
+					cleanup(t, err)
+				}
+				expectEquals(t, ctx, string(inspectFile("test.txt")), "Goodbye World!")
+				expectEquals(t, ctx, string(inspectFile("staged.txt")), "Staged")
+				expectEquals(t, ctx, string(inspectFile("untracked.txt")), "Untracked")
+
+				index := inspectIndex()
+				expectEquals(t, ctx, index["test.txt"], getOid([]byte("Hello World!"), BLOB))
+				expectEquals(t, ctx, index["staged.txt"], getOid([]byte("Staged"), BLOB))
+				_, tracked := index["untracked.txt"]
+				expectEquals(t, ctx, tracked, false)
+				expectExists(t, ctx, filepath.Join(GOGIT_DIR, STASH_REF), false)
+			},
+		},
+		{
+			Name:  "Reflog - Recover After Hard Reset",
+			Args:  CLIArgs{"HEAD@{1}"},
+			Flags: CLIFlags{},
+			Setup: func() {
+				setupInit()
+				setupCreateFile("test.txt", []byte("Hello World!"), false)
+				base.Add("test.txt")
+				base.Commit("first commit", time.Now())
+				setupCreateFile("test.txt", []byte("Goodbye World!"), false)
+				base.Add("test.txt")
+				base.Commit("second commit", time.Now())
+			},
+			Cleanup: func() {
+				cleanup(t, nil)
+			},
+			Run: func(args CLIArgs, flags CLIFlags) {
+				ctx := context.WithValue(context.Background(), TestName, "Reflog - Recover After Hard Reset")
+				secondOid := inspectRef("refs/heads/main")
+				if err := cli.Reset(args, CLIFlags{"hard": true}); err != nil {
+					cleanup(t, err)
+				}
+				expectEquals(t, ctx, string(inspectFile("test.txt")), "Hello World!")
+
+				entries, err := reflog.Read(HEAD)
+				if err != nil {
+					cleanup(t, err)
+				}
+				expectEquals(t, ctx, len(entries), 3)
+				expectEquals(t, ctx, entries[0].Message, "reset: moving to HEAD@{1}")
+				expectEquals(t, ctx, entries[1].NewOid, secondOid)
+
+				// The reset commit is only reachable from the reflog and survives garbage collection
+				if _, err := base.GC(); err != nil {
+					cleanup(t, err)
+				}
+				if err := cli.Reset(args, CLIFlags{"hard": true}); err != nil {
+					cleanup(t, err)
+				}
+				expectEquals(t, ctx, inspectRef("refs/heads/main"), secondOid)
+				expectEquals(t, ctx, string(inspectFile("test.txt")), "Goodbye World!")
+			},
+		},
+		{
+			Name:  "Refs - Locks and Transactions",
+			Args:  CLIArgs{},
+			Flags: CLIFlags{"message": "second commit"},
+			Setup: func() {
+				setupInit()
+				setupCreateFile("test.txt", []byte("Hello World!"), false)
+				base.Add("test.txt")
+				base.Commit("first commit", time.Now())
+				setupCreateFile("test.txt", []byte("Goodbye World!"), false)
+				base.Add("test.txt")
+			},
+			Cleanup: func() {
+				cleanup(t, nil)
+			},
+			Run: func(args CLIArgs, flags CLIFlags) {
+				ctx := context.WithValue(context.Background(), TestName, "Refs - Locks and Transactions")
+				firstOid := inspectRef("refs/heads/main")
+
+				// A ref locked by another process cannot be updated
+				lockPath := filepath.Join(GOGIT_DIR, "refs", "heads", "main"+LOCK_SUFFIX)
+				os.WriteFile(lockPath, []byte{}, FP)
+				_, err := base.Commit("second commit", time.Now())
+				_, isLockErr := err.(RefLockError)
+				expectEquals(t, ctx, isLockErr, true)
+				expectEquals(t, ctx, inspectRef("refs/heads/main"), firstOid)
+				os.Remove(lockPath)
+
+				if err := cli.Commit(args, flags); err != nil {
+					cleanup(t, err)
+				}
+				secondOid := inspectRef("refs/heads/main")
+
+				// No ref is written when any expected value does not match
+				tx := data.NewRefTransaction()
+				tx.Update("refs/heads/other", &RefValue{false, secondOid}, false, ZERO_OID, "")
+				tx.Update("refs/heads/main", &RefValue{false, firstOid}, false, firstOid, "")
+				_, isConflictErr := tx.Commit().(RefConflictError)
+				expectEquals(t, ctx, isConflictErr, true)
+				expectExists(t, ctx, filepath.Join(GOGIT_DIR, "refs", "heads", "other"), false)
+				expectExists(t, ctx, lockPath, false)
+				expectEquals(t, ctx, inspectRef("refs/heads/main"), secondOid)
+			},
+		},
+		{
+			Name:  "Rev Parse",
+			Args:  CLIArgs{},
+			Flags: CLIFlags{},
+			Setup: func() {
+				setupInit()
+				setupCreateFile("dir/test.txt", []byte("Hello World!"), false)
+				base.Add("dir/test.txt")
+				base.Commit("first commit", time.Now())
+				setupCreateFile("dir/test.txt", []byte("Goodbye World!"), false)
+				base.Add("dir/test.txt")
+				base.Commit("second commit", time.Now())
+				base.CreateBranch("feature", "@")
+				base.Checkout("feature", false, CHECKOUT_SAFE)
+				base.Checkout("main", false, CHECKOUT_SAFE)
+			},
+			Cleanup: func() {
+				cleanup(t, nil)
+			},
+			Run: func(args CLIArgs, flags CLIFlags) {
+				ctx := context.WithValue(context.Background(), TestName, "Rev Parse")
+				head := inspectRef("refs/heads/main")
+				commit, err := base.GetCommit(head)
+				if err != nil {
+					cleanup(t, err)
+				}
+				first := commit.ParentOids[0]
+
+				for rev, expected := range map[string]string{
+					head[:7]:              head,
+					"HEAD~1":              first,
+					"main^":               first,
+					"HEAD^{tree}":         commit.TreeOid,
+					"HEAD~1:dir/test.txt": getOid([]byte("Hello World!"), BLOB),
+					":dir/test.txt":       getOid([]byte("Goodbye World!"), BLOB),
+					":/first":             first,
+					"@{-1}":               head,
+				} {
+					oid, err := base.GetOid(rev)
+					if err != nil {
+						cleanup(t, err)
+					}
+					expectEquals(t, ctx, oid, expected)
+				}
+
+				// A prefix shared by several objects is ambiguous
+				setupCreateObject("abcd"+strings.Repeat("0", 36), []byte("blob\x00one"))
+				setupCreateObject("abcd"+strings.Repeat("1", 36), []byte("blob\x00two"))
+				_, err = base.GetOid("abcd")
+				_, ambiguous := err.(AmbiguousRevisionError)
+				expectEquals(t, ctx, ambiguous, true)
+			},
+		},
+		{
+			Name:  "Rev List - Ranges",
+			Args:  CLIArgs{},
+			Flags: CLIFlags{},
+			Setup: func() {
+				setupInit()
+				setupCreateFile("test.txt", []byte("Hello World!"), false)
+				base.Add("test.txt")
+				base.Commit("first commit", time.Now())
+				base.CreateBranch("feature", "@")
+				setupCreateFile("test.txt", []byte("Main"), false)
+				base.Add("test.txt")
+				base.Commit("main commit", time.Now())
+				base.Checkout("feature", false, CHECKOUT_SAFE)
+				setupCreateFile("feature.txt", []byte("Feature"), false)
+				base.Add("feature.txt")
+				base.Commit("feature commit", time.Now())
+			},
+			Cleanup: func() {
+				cleanup(t, nil)
+			},
+			Run: func(args CLIArgs, flags CLIFlags) {
+				ctx := context.WithValue(context.Background(), TestName, "Rev List - Ranges")
+				mainOid, featureOid := inspectRef("refs/heads/main"), inspectRef("refs/heads/feature")
+
+				for revs, expected := range map[string][]string{
+					"main..feature":        {featureOid},
+					"feature ^main":        {featureOid},
+					"feature --not main":   {featureOid},
+					"main...feature":       {mainOid, featureOid},
+					"main feature ^main~1": {mainOid, featureOid},
+				} {
+					commits, err := base.RevList(revision.ExpandNot(strings.Fields(revs)), false, ORDER_DEFAULT)
+					if err != nil {
+						cleanup(t, err)
+					}
+					oids := slices.Sorted(commits)
+					slices.Sort(expected)
+					expectEquals(t, ctx, strings.Join(oids, " "), strings.Join(expected, " "))
+				}
+			},
+		},
+		{
+			Name:  "Log - Formats and Filters",
+			Args:  CLIArgs{},
+			Flags: CLIFlags{},
+			Setup: func() {
+				setupInit()
+				os.Setenv("GOGIT_AUTHOR_NAME", "Ada")
+				os.Setenv("GOGIT_AUTHOR_EMAIL", "ada@example.com")
+				setupCreateFile("a.txt", []byte("a"), false)
+				base.Add("a.txt")
+				base.Commit("add a\n\nwith a body", time.Now().Add(-48*time.Hour))
+				os.Setenv("GOGIT_AUTHOR_NAME", "Bob")
+				setupCreateFile("b.txt", []byte("b"), false)
+				base.Add("b.txt")
+				base.Commit("add b", time.Now())
+			},
+			Cleanup: func() {
+				os.Unsetenv("GOGIT_AUTHOR_NAME")
+				os.Unsetenv("GOGIT_AUTHOR_EMAIL")
+				cleanup(t, nil)
+			},
+			Run: func(args CLIArgs, flags CLIFlags) {
+				ctx := context.WithValue(context.Background(), TestName, "Log - Formats and Filters")
+				head := inspectRef("refs/heads/main")
+
+				for expected, flags := range map[string]CLIFlags{
+					head[:7] + " (refs/heads/main) add b\n":   {"oneline": true, "max-count": 1},
+					"add a|Ada|ada@example.com|with a body\n": {"format": "%s|%an|%ae|%b", "author": "^Ada"},
+					"add a\nadd b\n": {"format": "tformat:%s", "reverse": true},
+					"add b\n":        {"format": "%s", "since": "1.day.ago", "paths": CLIArgs{"b.txt"}},
+					"add a\n":        {"format": "%s", "grep": "body", "no-merges": true},
+					"":               {"format": "%s", "merges": true},
+				} {
+					expectOutput(t, ctx, func() {
+						if err := cli.Log(args, flags); err != nil {
+							cleanup(t, err)
+						}
+					}, expected)
+				}
+			},
+		},
+		{
+			Name:  "Commit - Time",
+			Args:  CLIArgs{},
+			Flags: CLIFlags{},
+			Setup: func() {
+				setupInit()
+				setupCreateFile("a.txt", []byte("a"), false)
+				base.Add("a.txt")
+			},
+			Cleanup: func() {
+				cleanup(t, nil)
+			},
+			Run: func(args CLIArgs, flags CLIFlags) {
+				ctx := context.WithValue(context.Background(), TestName, "Commit - Time")
+				when := time.Date(2031, 5, 6, 7, 8, 9, 0, time.FixedZone("", 5*60*60+30*60))
+				oid, err := base.Commit("add a", when)
+				if err != nil {
+					cleanup(t, err)
+				}
+
+				// The time is stored like the author's, to the second and with its zone
+				object := string(inspectFile(filepath.Join(GOGIT_DIR, "objects", oid)))
+				expectEquals(t, ctx, strings.Contains(object, fmt.Sprintf("\ntime %d +0530\n", when.Unix())), true)
+				c, err := base.GetCommit(oid)
+				if err != nil {
+					cleanup(t, err)
+				}
+				expectEquals(t, ctx, c.Timestamp.Format(time.RFC3339), "2031-05-06T07:08:09+05:30")
+
+				// Commits written with time.Layout can still be read
+				content := fmt.Sprintf("tree %s\ntime %s\nmessage old", c.TreeOid, when.Format(time.Layout))
+				oldOid := getOid([]byte(content), COMMIT)
+				setupCreateObject(oldOid, []byte(fmt.Sprintf("commit\x00%s", content)))
+				old, err := base.GetCommit(oldOid)
+				if err != nil {
+					cleanup(t, err)
+				}
+				expectEquals(t, ctx, old.Timestamp.Equal(when), true)
+			},
+		},
+		{
+			Name:  "Log - Pickaxe",
+			Args:  CLIArgs{},
+			Flags: CLIFlags{},
+			Setup: func() {
+				setupInit()
+				for i, content := range []string{"foo\nbar\n", "foo\nbaz\n", "baz\nfoo\n"} {
+					setupCreateFile("test.txt", []byte(content), false)
+					base.Add("test.txt")
+					base.Commit(fmt.Sprintf("commit %d", i+1), time.Now())
+				}
+			},
+			Cleanup: func() {
+				cleanup(t, nil)
+			},
+			Run: func(args CLIArgs, flags CLIFlags) {
+				ctx := context.WithValue(context.Background(), TestName, "Log - Pickaxe")
+				for expected, flags := range map[string]CLIFlags{
+					// Moving a line keeps its count but adds and removes it
+					"commit 1\n":           {"format": "%s", "pickaxe": "foo"},
+					"commit 3\ncommit 1\n": {"format": "%s", "pickaxe-regex": "^fo+$"},
+					"commit 2\ncommit 1\n": {"format": "%s", "pickaxe": "bar"},
+					"commit 3\n":           {"format": "%s", "pickaxe-regex": "foo", "max-count": 1},
+				} {
+					expectOutput(t, ctx, func() {
+						if err := cli.Log(args, flags); err != nil {
+							cleanup(t, err)
+						}
+					}, expected)
+				}
+			},
+		},
+		{
+			Name:  "Diff - Renames",
+			Args:  CLIArgs{},
+			Flags: CLIFlags{"format": "%s", "follow": true, "paths": CLIArgs{"b.txt"}},
+			Setup: func() {
+				setupInit()
+				lines := strings.Repeat("line\n", 10)
+				setupCreateFile("a.txt", []byte(lines+"end\n"), false)
+				base.Add("a.txt")
+				base.Commit("add", time.Now())
+				base.Move("a.txt", "b.txt")
+				base.Commit("move", time.Now())
+				setupCreateFile("b.txt", []byte(lines+"changed\n"), false)
+				base.Add("b.txt")
+				base.Commit("edit", time.Now())
+			},
+			Cleanup: func() {
+				cleanup(t, nil)
+			},
+			Run: func(args CLIArgs, flags CLIFlags) {
+				ctx := context.WithValue(context.Background(), TestName, "Diff - Renames")
+				expectOutput(t, ctx, func() {
+					if err := cli.Log(args, flags); err != nil {
+						cleanup(t, err)
+					}
+				}, "edit\nmove\nadd\n")
+
+				from, _ := base.getCommitTree("@~2")
+				to, _ := base.getCommitTree("@")
+				for threshold, expected := range map[int][]string{
+					RENAME_THRESHOLD: {"renamed: a.txt -> b.txt"},
+					95:               {"deleted: a.txt", "new file: b.txt"},
+				} {
+					changes, err := diff.iterChangedFiles(from, to, RenameOptions{Threshold: threshold})
+					if err != nil {
+						cleanup(t, err)
+					}
+					var actual []string
+					for change := range changes {
+						actual = append(actual, change.String())
+					}
+					if !slices.Equal(actual, expected) {
+						t.Errorf("expected changes %v with a threshold of %d, received %v", expected, threshold, actual)
+					}
+				}
+
+				// -M takes the threshold attached, or none for the default one
+				for input, expected := range map[string]int{
+					"-M": RENAME_THRESHOLD, "-M60": 60, "-M70%": 70, "--find-renames": RENAME_THRESHOLD, "--find-renames=80": 80,
+				} {
+					_, flags, err := parseCLI("diff", expandFindRenames(CLIArgs{input}))
+					if err != nil {
+						cleanup(t, err)
+					}
+					opts, err := renameOptions(flags)
+					if err != nil {
+						cleanup(t, err)
+					}
+					expectEquals(t, ctx, opts.Threshold, expected)
+				}
+
+				// The edit made under the old name lands in the renamed file
+				moved, _ := base.getCommitTree("@~1")
+				merged, err := diff.MergeTrees(from, moved, Tree{"a.txt": to["b.txt"]})
+				if err != nil {
+					cleanup(t, err)
+				}
+				if _, ok := merged["a.txt"]; ok || merged["b.txt"] != to["b.txt"] {
+					t.Errorf("expected the edit to be merged into b.txt, received %v", merged)
+				}
+			},
+		},
+		{
+			Name:  "Log - Follow Across Branches",
+			Args:  CLIArgs{"main", "side"},
+			Flags: CLIFlags{"format": "%s", "follow": true, "paths": CLIArgs{"b.txt"}},
+			Setup: func() {
+				setupInit()
+				start := time.Now().Add(-24 * time.Hour)
+				setupCreateFile("a.txt", []byte(strings.Repeat("line\n", 10)), false)
+				base.Add("a.txt")
+				base.Commit("add a", start)
+				base.CreateBranch("side", "@")
+				base.Move("a.txt", "b.txt")
+				base.Commit("rename a to b", start.Add(3*time.Hour))
+				base.Checkout("side", false, CHECKOUT_SAFE)
+				setupCreateFile("b.txt", []byte("side\n"), false)
+				base.Add("b.txt")
+				base.Commit("add b", start.Add(time.Hour))
+				setupCreateFile("b.txt", []byte("side changed\n"), false)
+				base.Add("b.txt")
+				base.Commit("change b", start.Add(2*time.Hour))
+			},
+			Cleanup: func() {
+				cleanup(t, nil)
+			},
+			Run: func(args CLIArgs, flags CLIFlags) {
+				ctx := context.WithValue(context.Background(), TestName, "Log - Follow Across Branches")
+				// The rename on main is newest, yet the side branch keeps following its own b.txt
+				expectOutput(t, ctx, func() {
+					if err := cli.Log(args, flags); err != nil {
+						cleanup(t, err)
+					}
+				}, "rename a to b\nchange b\nadd b\nadd a\n")
+			},
+		},
+		{
+			Name:  "Diff - Output Modes",
+			Args:  CLIArgs{},
+			Flags: CLIFlags{},
+			Setup: func() {
+				setupInit()
+				setupCreateFile("test.txt", []byte("hello world\nsecond\n"), false)
+				base.Add("test.txt")
+				base.Commit("add", time.Now())
+				setupCreateFile("test.txt", []byte("hello there\nsecond\nthird\n"), false)
+			},
+			Cleanup: func() {
+				cleanup(t, nil)
+			},
+			Run: func(args CLIArgs, _ CLIFlags) {
+				ctx := context.WithValue(context.Background(), TestName, "Diff - Output Modes")
+				for expected, flags := range map[string]CLIFlags{
+					" test.txt | 3 ++-\n 1 file changed, 2 insertions(+), 1 deletion(-)\n": {"stat": true},
+					"2\t1\ttest.txt\n": {"numstat": true},
+					"test.txt\n":       {"name-only": true},
+					"M\ttest.txt\n":    {"name-status": true},
+					// The index matches HEAD, so there is nothing to summarise
+					"": {"stat": true, "cached": true},
+					"--- a/test.txt\n+++ b/test.txt\n@@ -1,2 +1,3 @@\nhello [-world-]{+there+}\nsecond\n{+third+}\n": {"word-diff": true},
+				} {
+					expectOutput(t, ctx, func() {
+						if err := cli.Diff(args, flags); err != nil {
+							cleanup(t, err)
+						}
+					}, expected)
+				}
+			},
+		},
+		{
+			Name:  "Log - Graph",
+			Args:  CLIArgs{},
+			Flags: CLIFlags{"graph": true, "format": "%s"},
+			Setup: func() {
+				setupInit()
+				tree, _ := base.WriteTree(".")
+				commit := func(message string, parents ...string) string {
+					oid, _ := base.writeCommit(CommitObject{tree, parents, "", time.Time{}, time.Now(), message})
+					return oid
+				}
+				root := commit("root")
+				octopus := commit("octopus", commit("a", root), commit("b", root), commit("c", root))
+				data.UpdateRef(HEAD, &RefValue{false, octopus}, true, "")
+			},
+			Cleanup: func() {
+				cleanup(t, nil)
+			},
+			Run: func(args CLIArgs, flags CLIFlags) {
+				ctx := context.WithValue(context.Background(), TestName, "Log - Graph")
+				expectOutput(t, ctx, func() {
+					if err := cli.Log(args, flags); err != nil {
+						cleanup(t, err)
+					}
+				}, strings.Join([]string{
+					"* octopus",
+					"|\\",
+					"| |\\",
+					"* | | a",
+					"| * | b",
+					"|/ /",
+					"| * c",
+					"|/",
+					"* root",
+				}, "\n")+"\n")
+			},
+		},
+		{
+			Name:  "Rev List - Orders",
+			Args:  CLIArgs{},
+			Flags: CLIFlags{},
+			Setup: func() {
+				setupInit()
+				tree, _ := base.WriteTree(".")
+				at := func(hours int) time.Time { return time.Unix(int64(hours)*3600, 0) }
+				commit := func(message string, authored, committed int, parents ...string) string {
+					oid, _ := base.writeCommit(CommitObject{tree, parents, "A <a@b>", at(authored), at(committed), message})
+					return oid
+				}
+				// The root's clock is skewed ahead of its children
+				root := commit("root", 9, 9)
+				a2 := commit("a2", 10, 3, commit("a1", 1, 1, root))
+				b2 := commit("b2", 4, 4, commit("b1", 2, 2, root))
+				data.UpdateRef(HEAD, &RefValue{false, commit("merge", 5, 5, a2, b2)}, true, "")
+			},
+			Cleanup: func() {
+				cleanup(t, nil)
+			},
+			Run: func(args CLIArgs, flags CLIFlags) {
+				ctx := context.WithValue(context.Background(), TestName, "Rev List - Orders")
+				for order, expected := range map[int]string{
+					ORDER_DEFAULT:     "merge b2 a2 b1 root a1",
+					ORDER_DATE:        "merge b2 a2 b1 a1 root",
+					ORDER_AUTHOR_DATE: "merge a2 b2 b1 a1 root",
+					ORDER_TOPO:        "merge a2 a1 b2 b1 root",
+				} {
+					commits, err := base.RevList(nil, false, order)
+					if err != nil {
+						cleanup(t, err)
+					}
+					var messages []string
+					for oid := range commits {
+						c, _ := base.GetCommit(oid)
+						messages = append(messages, c.Message)
+					}
+					expectEquals(t, ctx, strings.Join(messages, " "), expected)
+				}
+			},
+		},
+		{
+			Name:  "Blame",
+			Args:  CLIArgs{"test.txt"},
+			Flags: CLIFlags{"line-range": "2,+2", "porcelain": true},
+			Setup: func() {
+				setupInit()
+				setupCreateFile("test.txt", []byte("one\ntwo\nthree\n"), false)
+				base.Add("test.txt")
+				base.Commit("first commit", time.Now())
+				setupCreateFile("test.txt", []byte("zero\none\n2\nthree\n"), false)
+				base.Add("test.txt")
+				base.Commit("second commit", time.Now())
+			},
+			Cleanup: func() {
+				cleanup(t, nil)
+			},
+			Run: func(args CLIArgs, flags CLIFlags) {
+				ctx := context.WithValue(context.Background(), TestName, "Blame")
+				second := inspectRef("refs/heads/main")
+				first, _ := base.GetOid("main~1")
+
+				lines, _, err := blame.Lines(HEAD, "test.txt")
+				if err != nil {
+					cleanup(t, err)
+				}
+				var blamed []string
+				for _, line := range lines {
+					blamed = append(blamed, fmt.Sprintf("%s:%d", line.Oid, line.OrigLine))
+				}
+				expectEquals(t, ctx, strings.Join(blamed, " "), fmt.Sprintf("%s:1 %s:1 %s:3 %s:3", second, first, second, first))
+
+				rescueStdout := os.Stdout
+				r, w, _ := os.Pipe()
+				os.Stdout = w
+				err = cli.Blame(args, flags)
+				w.Close()
+				out, _ := io.ReadAll(r)
+				os.Stdout = rescueStdout
+				if err != nil {
+					cleanup(t, err)
+				}
+				output := strings.Split(string(out), "\n")
+				expectEquals(t, ctx, output[0], first+" 1 2 1")
+				expectEquals(t, ctx, output[2], "author-mail <"+strings.Split(base.author(), "<")[1])
+				expectEquals(t, ctx, output[6], "filename test.txt")
+				expectEquals(t, ctx, output[7], "\tone")
+				expectEquals(t, ctx, output[8], second+" 3 3 1")
+			},
+		},
+		{
+			Name:  "Bisect",
+			Args:  CLIArgs{"start", "main", "main~7"},
+			Flags: CLIFlags{},
+			Setup: func() {
+				setupInit()
+				for i := 1; i <= 8; i++ {
+					setupCreateFile("n.txt", []byte(strconv.Itoa(i)), false)
+					base.Add("n.txt")
+					base.Commit(fmt.Sprintf("commit %d", i), time.Now())
+				}
+			},
+			Cleanup: func() {
+				cleanup(t, nil)
+			},
+			Run: func(args CLIArgs, flags CLIFlags) {
+				ctx := context.WithValue(context.Background(), TestName, "Bisect")
+				firstBad, _ := base.GetOid("main~2")
+				if err := cli.Bisect(args, flags); err != nil {
+					cleanup(t, err)
+				}
+				expectExists(t, ctx, filepath.Join(GOGIT_DIR, BISECT_START), true)
+
+				// Commits from 6 on are bad
+				tested := 0
+				for {
+					n, _ := strconv.Atoi(string(inspectFile("n.txt")))
+					term := BISECT_TERM_GOOD
+					if n >= 6 {
+						term = BISECT_TERM_BAD
+					}
+					if err := bisect.Mark(term, HEAD); err != nil {
+						cleanup(t, err)
+					}
+					tested++
+					step, err := bisect.Next()
+					if err != nil {
+						cleanup(t, err)
+					}
+					if step.FirstBad != "" {
+						expectEquals(t, ctx, step.FirstBad, firstBad)
+						break
+					}
+				}
+				expectEquals(t, ctx, tested, 3)
+
+				if err := cli.Bisect(CLIArgs{"reset"}, flags); err != nil {
+					cleanup(t, err)
+				}
+				expectEquals(t, ctx, inspectRef(HEAD), "ref: refs/heads/main")
+				expectExists(t, ctx, filepath.Join(GOGIT_DIR, BISECT_LOG), false)
+			},
+		},
+		{
+			Name:  "Grep",
+			Args:  CLIArgs{"hello"},
+			Flags: CLIFlags{"line-number": true, "ignore-case": true},
+			Setup: func() {
+				setupInit()
+				setupCreateFile("a.txt", []byte("Hello\nworld\n"), false)
+				setupCreateFile("b.txt", []byte("say hello\n"), false)
+				setupCreateFile("binary.bin", []byte("hello\x00"), false)
+				base.Add(".")
+				base.Commit("first commit", time.Now())
+				setupCreateFile("b.txt", []byte("goodbye\n"), false)
+			},
+			Cleanup: func() {
+				cleanup(t, nil)
+			},
+			Run: func(args CLIArgs, flags CLIFlags) {
+				ctx := context.WithValue(context.Background(), TestName, "Grep")
+				grepOutput := func(args CLIArgs, flags CLIFlags) func() {
+					return func() {
+						if err := cli.Grep(args, flags); err != nil {
+							cleanup(t, err)
+						}
+					}
+				}
+
+				// The working directory is searched by default, skipping binary files
+				expectOutput(t, ctx, grepOutput(args, flags), "a.txt:1:Hello\n")
+				expectOutput(t, ctx, grepOutput(CLIArgs{"hel+o"}, CLIFlags{"cached": true, "extended-regexp": true}), "b.txt:say hello\n")
+				expectOutput(t, ctx, grepOutput(CLIArgs{"main", "o$"}, CLIFlags{"paths": CLIArgs{"b.txt"}}), "main:b.txt:say hello\n")
+			},
+		},
+		{
+			Name:  "Merge and Commit",
+			Args:  CLIArgs{"main"},
+			Flags: CLIFlags{},
+			Setup: func() {
+				setupInit()
+				setupCommit("main", "main-commit-1", "", map[string][]byte{"test-1.txt": []byte("Hello World!")})
+				// Create branch off main with 2 commits
+				setupBranch("first-branch", "main", 2)
+				// Make another commit on main
+				setupCommit("main", "main-commit-2", "main-commit-1", map[string][]byte{"test-1.txt": []byte("Hello World Again!")})
+				// set HEAD to new branch
+				setHEAD("first-branch")
+			},
+			Cleanup: func() {
+				cleanup(t, nil)
+			},
+			Run: func(args CLIArgs, flags CLIFlags) {
+				ctx := context.WithValue(context.Background(), TestName, "Merge and Commit")
+				if err := cli.Merge(args, flags); err != nil {
+					cleanup(t, err)
+				}
+
+				expectExists(t, ctx, filepath.Join(GOGIT_DIR, MERGE_HEAD), true)
+				expectEquals(t, ctx, inspectRef(MERGE_HEAD), fixtureOids["main-commit-2"])
+				expectEquals(t, ctx, inspectRef(HEAD), "ref: refs/heads/first-branch")
+
+				if err := cli.Commit(CLIArgs{}, CLIFlags{"message": "merge commit"}); err != nil {
+					cleanup(t, err)
+				}
+
+				expectExists(t, ctx, filepath.Join(GOGIT_DIR, MERGE_HEAD), false)
+				commit := inspectFile(filepath.Join(GOGIT_DIR, "objects", inspectRef("refs/heads/first-branch")))
+				expectEquals(t, ctx, strings.Count(string(commit), "parent"), 2)
+			},
+		},
+		{
+			Name:  "Merge - Fast Forward",
+			Args:  CLIArgs{"main"},
+			Flags: CLIFlags{},
+			Setup: func() {
+				setupInit()
+				setupCommit("main", "main-commit-1", "", map[string][]byte{"test-1.txt": []byte("Hello World!")})
+				// Create branch off main with 0 commits
+				setupBranch("first-branch", "main", 0)
+				// Make another commit on main
+				setupCommit("main", "main-commit-2", "main-commit-1", map[string][]byte{"test-1.txt": []byte("Hello World Again!")})
+				// set HEAD to new branch
+				setHEAD("first-branch")
+			},
+			Cleanup: func() {
+				cleanup(t, nil)
+			},
+			Run: func(args CLIArgs, flags CLIFlags) {
+				ctx := context.WithValue(context.Background(), TestName, "Merge - Fast Forward")
+				if err := cli.Merge(args, flags); err != nil {
+					cleanup(t, err)
+				}
+
+				expectExists(t, ctx, filepath.Join(GOGIT_DIR, MERGE_HEAD), false)
+				expectEquals(t, ctx, inspectRef(HEAD), "ref: refs/heads/first-branch")
+				expectEquals(t, ctx, inspectRef("refs/heads/first-branch"), fixtureOids["main-commit-2"])
+			},
+		},
+		{
+			Name:  "Commits And Parents - Merge With Queued Commits",
+			Args:  CLIArgs{},
+			Flags: CLIFlags{},
+			Setup: func() {
+				setupInit()
+			},
+			Cleanup: func() {
+				cleanup(t, nil)
+			},
+			Run: func(_ CLIArgs, _ CLIFlags) {
+				ctx := context.WithValue(context.Background(), TestName, "Commits And Parents - Merge With Queued Commits")
+				commit := func(message string, parents ...string) string {
+					var content string
+					for _, parent := range parents {
+						content += fmt.Sprintf("parent %s\n", parent)
+					}
+					content += "message " + message
+					oid := getOid([]byte(content), COMMIT)
+					setupCreateObject(oid, []byte(fmt.Sprintf("commit\x00%s", content)))
+					return oid
+				}
+				root := commit("root")
+				merge := commit("merge", commit("first", root), commit("second", root))
+
+				// Another commit is queued when the merge's parents are added
+				count := 0
+				for range base.iterCommitsAndParents([]string{merge, root}) {
+					count++
+				}
+				expectEquals(t, ctx, count, 4)
+			},
+		},
+		{
+			Name:  "Write Tree - Entries Are Sorted",
+			Args:  CLIArgs{},
+			Flags: CLIFlags{},
+			Setup: func() {
+				setupInit()
+				for _, name := range []string{"e.txt", "b.txt", "d.txt", "a.txt", "c.txt"} {
+					setupCreateFile(name, []byte(name), false)
+				}
+				cli.Add(CLIArgs{"."}, CLIFlags{})
+			},
+			Cleanup: func() {
+				cleanup(t, nil)
+			},
+			Run: func(_ CLIArgs, _ CLIFlags) {
+				ctx := context.WithValue(context.Background(), TestName, "Write Tree - Entries Are Sorted")
+				var expected string
+				for _, name := range []string{"a.txt", "b.txt", "c.txt", "d.txt", "e.txt"} {
+					expected += fmt.Sprintf("%s %s blob\n", name, getOid([]byte(name), BLOB))
+				}
+
+				// The same content always hashes to the same tree, whatever order the index is read in
+				for range 10 {
+					oid, err := base.WriteTree(".")
+					if err != nil {
+						cleanup(t, err)
+					}
+					expectEquals(t, ctx, oid, getOid([]byte(expected), TREE))
+				}
+			},
+		},
+		{
+			Name:  "Merge Trees - One Sided Changes",
+			Args:  CLIArgs{},
+			Flags: CLIFlags{},
+			Setup: func() {
+				setupInit()
+			},
+			Cleanup: func() {
+				cleanup(t, nil)
+			},
+			Run: func(_ CLIArgs, _ CLIFlags) {
+				ctx := context.WithValue(context.Background(), TestName, "Merge Trees - One Sided Changes")
+				blob := func(content string) string {
+					oid, err := data.HashObject([]byte(content), BLOB)
+					if err != nil {
+						cleanup(t, err)
+					}
+					return oid
+				}
+				a, b, changed := blob("a\n"), blob("b\n"), blob("no trailing newline")
+
+				// head deletes b.txt and the merged commit changes a.txt, neither touches the other's file
+				merged, err := diff.MergeTrees(
+					Tree{"a.txt": a, "b.txt": b},
+					Tree{"a.txt": a},
+					Tree{"a.txt": changed, "b.txt": b},
+				)
+				if err != nil {
+					cleanup(t, err)
+				}
+				expectEquals(t, ctx, len(merged), 1)
+				expectEquals(t, ctx, merged["a.txt"], changed)
+			},
+		},
+		{
+			Name:  "Rebase",
+			Args:  CLIArgs{"main"},
+			Flags: CLIFlags{},
+			Setup: func() {
+				setupInit()
+				setupCommit("main", "main-commit-1", "", map[string][]byte{"test-1.txt": []byte("Hello World!")})
+				// Create branch off main with 2 commits
+				setupBranch("first-branch", "main", 2)
+				// Make another commit on main
+				setupCommit("main", "main-commit-2", "main-commit-1", map[string][]byte{"test-1.txt": []byte("Hello World Again!")})
+				// set HEAD to new branch
+				setHEAD("first-branch")
+			},
+			Cleanup: func() {
+				cleanup(t, nil)
+			},
+			Run: func(args CLIArgs, flags CLIFlags) {
+				ctx := context.WithValue(context.Background(), TestName, "Rebase")
+				if err := cli.Rebase(args, flags); err != nil {
+					cleanup(t, err)
+				}
+
+				expectEquals(t, ctx, inspectRef(HEAD), "ref: refs/heads/first-branch")
+
+				// Rebase applies new commits so the commit ids of branch "first-branch" should be different
+				currRef := inspectRef("refs/heads/first-branch")
+				expectNotEquals(t, ctx, currRef, fixtureOids["first-branch-commit-2"])
+
+				commit, _ := base.GetCommit(currRef)
+				currRef = commit.ParentOids[0]
+				expectNotEquals(t, ctx, currRef, fixtureOids["first-branch-commit-1"])
+
+				commit, _ = base.GetCommit(currRef)
+				currRef = commit.ParentOids[0]
+				expectEquals(t, ctx, currRef, fixtureOids["main-commit-2"])
+
+				commit, _ = base.GetCommit(currRef)
+				currRef = commit.ParentOids[0]
+				expectEquals(t, ctx, currRef, fixtureOids["main-commit-1"])
+			},
+		},
+		{
+			Name:  "Reset - Mixed, Hard and Paths",
+			Args:  CLIArgs{"v1"},
+			Flags: CLIFlags{},
+			Setup: func() {
+				setupInit()
+				setupCommit("main", "main-commit-1", "", map[string][]byte{"test.txt": []byte("Hello World!")})
+				setupCommit("main", "main-commit-2", "main-commit-1", map[string][]byte{"test.txt": []byte("Goodbye World!")})
+				os.WriteFile(filepath.Join(GOGIT_DIR, "refs", "tags", "v1"), []byte(fixtureOids["main-commit-1"]), FP)
+			},
+			Cleanup: func() {
+				cleanup(t, nil)
+			},
+			Run: func(args CLIArgs, flags CLIFlags) {
+				ctx := context.WithValue(context.Background(), TestName, "Reset - Mixed, Hard and Paths")
+				expectOutput(t, ctx, func() {
+					if err := cli.Reset(args, flags); err != nil {
+						cleanup(t, err)
+					}
+				}, "v1\n")
+
+				// Mixed reset moves HEAD and the index but leaves the working tree alone
+				expectEquals(t, ctx, inspectRef("refs/heads/main"), fixtureOids["main-commit-1"])
+				expectEquals(t, ctx, inspectIndex()["test.txt"], getOid([]byte("Hello World!"), BLOB))
+				expectEquals(t, ctx, string(inspectFile("test.txt")), "Goodbye World!")
+
+				// Unstaging a path resets its index entry to HEAD
+				base.Add("test.txt")
+				expectEquals(t, ctx, inspectIndex()["test.txt"], getOid([]byte("Goodbye World!"), BLOB))
+				if err := cli.Reset(CLIArgs{}, CLIFlags{"paths": CLIArgs{"test.txt"}}); err != nil {
+					cleanup(t, err)
+				}
+				expectEquals(t, ctx, inspectIndex()["test.txt"], getOid([]byte("Hello World!"), BLOB))
+
+				expectOutput(t, ctx, func() {
+					if err := cli.Reset(args, CLIFlags{"hard": true}); err != nil {
+						cleanup(t, err)
+					}
+				}, "v1\n")
+				expectEquals(t, ctx, string(inspectFile("test.txt")), "Hello World!")
+			},
+		},
+		{
+			Name:  "GC",
+			Args:  CLIArgs{},
+			Flags: CLIFlags{},
+			Setup: func() {
+				setupInit()
+
+				setupCommit("new-branch-1", "new-branch-1-commit-1", "", map[string][]byte{
+					"test-branch-1.txt": []byte("Hello World 1!"),
+				})
+
+				setupCommit("new-branch-2", "new-branch-2-commit-1", "", map[string][]byte{
+					"test-branch-2.txt": []byte("Hello World 2!"),
+				})
+
+				// Remove new-branch-1 ref (commit, tree, and blob are now unreachable)
+				if err := os.Remove(filepath.Join(GOGIT_DIR, "refs", "heads", "new-branch-1")); err != nil {
+					cleanup(t, err)
+				}
+
+				// An object another process is still writing
+				os.WriteFile(filepath.Join(GOGIT_DIR, TMP_DIR, "obj-1"), []byte("blob\x00"), FP)
+			},
+			Cleanup: func() {
+				cleanup(t, nil)
+			},
+			Run: func(args CLIArgs, flags CLIFlags) {
+				ctx := context.WithValue(context.Background(), TestName, "GC")
+				if err := cli.GC(args, flags); err != nil {
+					cleanup(t, err)
+				}
+
+				// commit, tree, and test.txt blob from new-branch-2 should not be deleted
+				expectDirLength(t, ctx, filepath.Join(GOGIT_DIR, "objects"), 3)
+				oid := inspectRef("refs/heads/new-branch-2")
+				expectNotEquals(t, ctx, oid, "")
+				expectExists(t, ctx, filepath.Join(GOGIT_DIR, "objects", oid), true)
+
+				// Refs are packed once objects have been pruned
+				expectExists(t, ctx, filepath.Join(GOGIT_DIR, "refs", "heads", "new-branch-2"), false)
+				expectExists(t, ctx, filepath.Join(GOGIT_DIR, TMP_DIR, "obj-1"), true)
+			},
+		},
+		{
+			Name:  "Commit Graph - Generations",
+			Args:  CLIArgs{},
+			Flags: CLIFlags{},
+			Setup: func() {
+				setupInit()
+				setupCreateFile("test.txt", []byte("Hello World!"), false)
+				base.Add("test.txt")
+				base.Commit("first commit", time.Now())
+				base.CreateBranch("feature", "@")
+				setupCreateFile("test.txt", []byte("Main"), false)
+				base.Add("test.txt")
+				base.Commit("main commit", time.Now())
+				base.Checkout("feature", false, CHECKOUT_SAFE)
+				setupCreateFile("feature.txt", []byte("Feature"), false)
+				base.Add("feature.txt")
+				base.Commit("feature commit", time.Now())
+				setupCreateFile("feature.txt", []byte("Feature 2"), false)
+				base.Add("feature.txt")
+				base.Commit("second feature commit", time.Now())
+			},
+			Cleanup: func() {
+				cleanup(t, nil)
+			},
+			Run: func(args CLIArgs, flags CLIFlags) {
+				ctx := context.WithValue(context.Background(), TestName, "Commit Graph - Generations")
+				root, _ := base.GetOid("main~1")
+				mainOid, featureOid := inspectRef("refs/heads/main"), inspectRef("refs/heads/feature")
+
+				entries, err := commitGraph.read()
+				if err != nil {
+					cleanup(t, err)
+				}
+				expectEquals(t, ctx, len(entries), 4)
+				expectEquals(t, ctx, entries[root].Generation, 1)
+				expectEquals(t, ctx, entries[mainOid].Generation, 2)
+				expectEquals(t, ctx, entries[featureOid].Generation, 3)
+
+				expectEquals(t, ctx, base.isAncestorOf(root, featureOid), true)
+				expectEquals(t, ctx, base.isAncestorOf(mainOid, featureOid), false)
+				mergeBase, err := base.getMergeBase(mainOid, featureOid)
+				if err != nil {
+					cleanup(t, err)
+				}
+				expectEquals(t, ctx, mergeBase, root)
+
+				// Commits pruned by gc leave the graph with them
+				base.Reset("@~1", RESET_HARD)
+				os.RemoveAll(filepath.Join(GOGIT_DIR, "logs"))
+				if _, err := base.GC(); err != nil {
+					cleanup(t, err)
+				}
+				if entries, err = commitGraph.read(); err != nil {
+					cleanup(t, err)
+				}
+				expectEquals(t, ctx, len(entries), 3)
+				_, ok := entries[featureOid]
+				expectEquals(t, ctx, ok, false)
+
+				// A commit appends its line and leaves the rest of the file alone
+				before := string(inspectFile(filepath.Join(GOGIT_DIR, COMMIT_GRAPH_FILE)))
+				expectEquals(t, ctx, strings.Count(before, "\n"), 3)
+				setupCreateFile("feature.txt", []byte("Feature 3"), false)
+				base.Add("feature.txt")
+				oid, err := base.Commit("third feature commit", time.Now())
+				if err != nil {
+					cleanup(t, err)
+				}
+				after := string(inspectFile(filepath.Join(GOGIT_DIR, COMMIT_GRAPH_FILE)))
+				expectEquals(t, ctx, strings.HasPrefix(after, before), true)
+				expectEquals(t, ctx, strings.Count(after, "\n"), 4)
+				if entries, err = commitGraph.read(); err != nil {
+					cleanup(t, err)
+				}
+				expectEquals(t, ctx, entries[oid].Generation, 3)
+			},
+		},
+		{
+			Name:  "Pack Refs",
+			Args:  CLIArgs{},
+			Flags: CLIFlags{"all": true},
+			Setup: func() {
+				setupInit()
+				setupCreateFile("test.txt", []byte("Hello World!"), false)
+				base.Add("test.txt")
+				base.Commit("first commit", time.Now())
+				oid, _ := base.GetOid("@")
+				base.CreateTag("v1", oid)
+				base.CreateBranch("feature", "@")
+			},
+			Cleanup: func() {
+				cleanup(t, nil)
+			},
+			Run: func(args CLIArgs, flags CLIFlags) {
+				ctx := context.WithValue(context.Background(), TestName, "Pack Refs")
+				headOid := inspectRef("refs/heads/main")
+				if err := cli.PackRefs(args, flags); err != nil {
+					cleanup(t, err)
+				}
+
+				expectExists(t, ctx, filepath.Join(GOGIT_DIR, "refs", "tags", "v1"), false)
+				expectExists(t, ctx, filepath.Join(GOGIT_DIR, "refs", "heads", "feature"), false)
+				oid, err := base.GetOid("v1")
+				if err != nil {
+					cleanup(t, err)
+				}
+				expectEquals(t, ctx, oid, headOid)
+
+				branches := []string{}
+				branchIter, err := base.iterBranches()
+				if err != nil {
+					cleanup(t, err)
+				}
+				for name := range branchIter {
+					branches = append(branches, name)
+				}
+				expectEquals(t, ctx, len(branches), 2)
+
+				// Deleting a packed ref removes it from the packed refs file
+				if err := data.DeleteRef("refs/heads/feature", false); err != nil {
+					cleanup(t, err)
+				}
+				expectEquals(t, ctx, inspectRef("refs/heads/feature"), "")
+				expectEquals(t, ctx, inspectRef("refs/tags/v1"), headOid)
 			},
 		},
 	}
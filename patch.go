@@ -0,0 +1,354 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type Patch struct{}
+
+// Namespacing
+var patch Patch
+
+// Hunk is a single section of a unified diff. Lines keep their ' ', '-' or '+' prefix and trailing newline
+type Hunk struct {
+	FromStart int
+	FromCount int
+	ToStart   int
+	ToCount   int
+	Lines     []string
+}
+
+func (h Hunk) String() string {
+	return fmt.Sprintf("@@ -%d,%d +%d,%d @@", h.FromStart, h.FromCount, h.ToStart, h.ToCount)
+}
+
+var hunkHeaderRegex = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+// parseHunks parses the output of a unified diff into hunks
+func (Patch) parseHunks(out []byte) ([]Hunk, error) {
+	var hunks []Hunk
+	for _, line := range strings.SplitAfter(string(out), "\n") {
+		if line == "" {
+			continue
+		}
+
+		if match := hunkHeaderRegex.FindStringSubmatch(line); match != nil {
+			counts := make([]int, 4)
+			for i, group := range match[1:] {
+				counts[i] = 1
+				if group != "" {
+					counts[i], _ = strconv.Atoi(group)
+				}
+			}
+			hunks = append(hunks, Hunk{counts[0], counts[1], counts[2], counts[3], nil})
+			continue
+		}
+
+		if len(hunks) == 0 {
+			// File headers come before the first hunk
+			continue
+		}
+
+		h := &hunks[len(hunks)-1]
+		switch line[0] {
+		case ' ', '-', '+':
+			h.Lines = append(h.Lines, line)
+		case '\\':
+			// "\ No newline at end of file" applies to the previous line
+			if n := len(h.Lines); n > 0 {
+				h.Lines[n-1] = strings.TrimSuffix(h.Lines[n-1], "\n")
+			}
+		default:
+			return nil, fmt.Errorf("malformed diff line: %q", line)
+		}
+	}
+	return hunks, nil
+}
+
+// getHunks diffs two blobs and returns the hunks needed to turn the first into the second
+func (Patch) getHunks(path, fromOid, toOid string) ([]Hunk, error) {
+	out, err := diff.DiffBlobs(path, []string{fromOid, toOid})
+	if err != nil {
+		return nil, err
+	}
+	return patch.parseHunks(out)
+}
+
+// fromLines returns the lines of the original content the hunk covers
+func (h Hunk) fromLines() []string {
+	var lines []string
+	for _, line := range h.Lines {
+		if line[0] != '+' {
+			lines = append(lines, line[1:])
+		}
+	}
+	return lines
+}
+
+// applyHunks applies the hunks, sorted by position, to content
+func (Patch) applyHunks(content []byte, hunks []Hunk) ([]byte, error) {
+	from := strings.SplitAfter(string(content), "\n")
+	if from[len(from)-1] == "" {
+		from = from[:len(from)-1]
+	}
+
+	var out strings.Builder
+	cursor := 0
+	for _, h := range hunks {
+		// Hunks without original lines insert after FromStart
+		start := h.FromStart - 1
+		if h.FromCount == 0 {
+			start = h.FromStart
+		}
+		if start < cursor || start+h.FromCount > len(from) {
+			return nil, fmt.Errorf("hunk %s does not apply", h)
+		}
+
+		for _, line := range from[cursor:start] {
+			out.WriteString(line)
+		}
+		for i, line := range h.fromLines() {
+			if strings.TrimSuffix(from[start+i], "\n") != strings.TrimSuffix(line, "\n") {
+				return nil, fmt.Errorf("hunk %s does not apply", h)
+			}
+		}
+		for _, line := range h.Lines {
+			if line[0] != '-' {
+				out.WriteString(line[1:])
+			}
+		}
+		cursor = start + h.FromCount
+	}
+
+	for _, line := range from[cursor:] {
+		out.WriteString(line)
+	}
+	return []byte(out.String()), nil
+}
+
+// splitHunk splits a hunk into smaller hunks around each group of changes. The context between
+// two groups is given to the later one so the resulting hunks never overlap
+func (Patch) splitHunk(h Hunk) []Hunk {
+	var hunks []Hunk
+	current := Hunk{FromStart: h.FromStart, ToStart: h.ToStart}
+	fromLine, toLine := h.FromStart, h.ToStart
+	seenChange := false
+
+	for i, line := range h.Lines {
+		// A context line following a change, with more changes to come, starts a new hunk
+		if line[0] == ' ' && seenChange && i > 0 && h.Lines[i-1][0] != ' ' {
+			hasMoreChanges := false
+			for _, next := range h.Lines[i:] {
+				if next[0] != ' ' {
+					hasMoreChanges = true
+					break
+				}
+			}
+			if hasMoreChanges {
+				// As in diff, a hunk without lines on one side starts at the line before it
+				if current.FromCount == 0 {
+					current.FromStart--
+				}
+				if current.ToCount == 0 {
+					current.ToStart--
+				}
+				hunks = append(hunks, current)
+				current = Hunk{FromStart: fromLine, ToStart: toLine}
+				seenChange = false
+			}
+		}
+
+		current.Lines = append(current.Lines, line)
+		switch line[0] {
+		case ' ':
+			current.FromCount++
+			current.ToCount++
+			fromLine++
+			toLine++
+		case '-':
+			current.FromCount++
+			fromLine++
+			seenChange = true
+		case '+':
+			current.ToCount++
+			toLine++
+			seenChange = true
+		}
+	}
+	return append(hunks, current)
+}
+
+func (Patch) editor() string {
+	for _, env := range []string{"GOGIT_EDITOR", "VISUAL", "EDITOR"} {
+		if editor := os.Getenv(env); editor != "" {
+			return editor
+		}
+	}
+	return "vi"
+}
+
+// editHunk opens the hunk in the user's editor and parses it back. Only added lines may be changed
+// freely, the original lines must stay as they were (though removals may be turned into context)
+func (Patch) editHunk(h Hunk) (Hunk, error) {
+	f, err := os.CreateTemp("", "gogit-hunk-*.diff")
+	if err != nil {
+		return h, err
+	}
+	defer os.Remove(f.Name())
+
+	content := "# Manual hunk edit mode\n" +
+		"# To remove '-' lines, make them ' ' lines (context).\n" +
+		"# To remove '+' lines, delete them.\n" +
+		"# Lines starting with # will be removed.\n" +
+		h.String() + "\n"
+	for _, line := range h.Lines {
+		content += strings.TrimSuffix(line, "\n") + "\n"
+	}
+	if _, err = f.WriteString(content); err != nil {
+		f.Close()
+		return h, err
+	}
+	f.Close()
+
+	cmd := exec.Command("sh", "-c", patch.editor()+` "$0"`, f.Name())
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err = cmd.Run(); err != nil {
+		return h, err
+	}
+
+	buf, err := os.ReadFile(f.Name())
+	if err != nil {
+		return h, err
+	}
+
+	edited := Hunk{FromStart: h.FromStart, ToStart: h.ToStart}
+	for _, line := range strings.SplitAfter(string(buf), "\n") {
+		if line == "" || line[0] == '#' || strings.HasPrefix(line, "@@") {
+			continue
+		}
+		switch line[0] {
+		case ' ', '-', '+':
+		default:
+			// Editors may strip the space from blank context lines
+			if strings.TrimSpace(line) != "" {
+				return h, fmt.Errorf("edited hunk has an invalid line: %q", line)
+			}
+			line = " " + line
+		}
+		edited.Lines = append(edited.Lines, line)
+		if line[0] != '+' {
+			edited.FromCount++
+		}
+		if line[0] != '-' {
+			edited.ToCount++
+		}
+	}
+
+	original, changed := h.fromLines(), edited.fromLines()
+	if len(original) != len(changed) {
+		return h, fmt.Errorf("edited hunk does not apply")
+	}
+	for i := range original {
+		if strings.TrimSuffix(original[i], "\n") != strings.TrimSuffix(changed[i], "\n") {
+			return h, fmt.Errorf("edited hunk does not apply")
+		}
+	}
+	return edited, nil
+}
+
+func (Patch) printHunk(h Hunk) {
+	fmt.Println(h)
+	for _, line := range h.Lines {
+		line = strings.TrimSuffix(line, "\n")
+		if strings.TrimSpace(line) == "" {
+			fmt.Println(line)
+			continue
+		}
+		diff.PrettyPrint(line)
+	}
+}
+
+// SelectHunks shows every hunk of the diff between two blobs and asks which to apply.
+// It returns the content of fromOid with the chosen hunks applied, whether any hunk was
+// chosen, and whether the user asked to quit
+func (Patch) SelectHunks(in *bufio.Reader, path, fromOid, toOid, action string) ([]byte, bool, bool, error) {
+	from, _, err := data.GetObject(fromOid)
+	if err != nil {
+		return nil, false, false, err
+	}
+
+	hunks, err := patch.getHunks(path, fromOid, toOid)
+	if err != nil {
+		return nil, false, false, err
+	}
+
+	var selected []Hunk
+	quit := false
+	fmt.Printf("--- a/%s\n+++ b/%s\n", path, path)
+	for i := 0; i < len(hunks); i++ {
+		patch.printHunk(hunks[i])
+		fmt.Printf("(%d/%d) %s this hunk [y,n,q,a,d,s,e,?]? ", i+1, len(hunks), action)
+
+		answer, err := in.ReadString('\n')
+		if err != nil && answer == "" {
+			// End of input is treated like quitting
+			quit = true
+			break
+		}
+
+		switch strings.TrimSpace(answer) {
+		case "y":
+			selected = append(selected, hunks[i])
+		case "n":
+		case "q":
+			quit = true
+		case "a":
+			selected = append(selected, hunks[i:]...)
+			i = len(hunks)
+		case "d":
+			i = len(hunks)
+		case "s":
+			split := patch.splitHunk(hunks[i])
+			if len(split) == 1 {
+				fmt.Println("Sorry, cannot split this hunk")
+			} else {
+				fmt.Printf("Split into %d hunks.\n", len(split))
+			}
+			hunks = append(hunks[:i], append(split, hunks[i+1:]...)...)
+			i--
+		case "e":
+			edited, err := patch.editHunk(hunks[i])
+			if err != nil {
+				fmt.Println(err)
+				i--
+				continue
+			}
+			selected = append(selected, edited)
+		default:
+			fmt.Printf("y - %s this hunk\n", strings.ToLower(action))
+			fmt.Printf("n - do not %s this hunk\n", strings.ToLower(action))
+			fmt.Printf("q - quit; do not %s this hunk or any of the remaining ones\n", strings.ToLower(action))
+			fmt.Printf("a - %s this hunk and all later hunks in the file\n", strings.ToLower(action))
+			fmt.Printf("d - do not %s this hunk or any of the later hunks in the file\n", strings.ToLower(action))
+			fmt.Println("s - split the current hunk into smaller hunks")
+			fmt.Println("e - manually edit the current hunk")
+			fmt.Println("? - print help")
+			i--
+		}
+		if quit {
+			break
+		}
+	}
+
+	if len(selected) == 0 {
+		return from, false, quit, nil
+	}
+	out, err := patch.applyHunks(from, selected)
+	return out, true, quit, err
+}
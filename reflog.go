@@ -0,0 +1,295 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Reflog struct{}
+
+// Namespacing
+var reflog Reflog
+
+// Old oid of an entry recording the creation of a ref
+var ZERO_OID = strings.Repeat("0", 40)
+
+// Entries older than this are removed by "reflog expire" unless told otherwise
+const REFLOG_EXPIRE_DEFAULT = "90.days.ago"
+
+// ReflogEntry is a single line of a ref's log
+type ReflogEntry struct {
+	OldOid    string
+	NewOid    string
+	Name      string
+	Email     string
+	Timestamp time.Time
+	Message   string
+}
+
+func (e ReflogEntry) String() string {
+	return fmt.Sprintf(
+		"%s %s %s <%s> %d %s\t%s",
+		e.OldOid, e.NewOid, e.Name, e.Email, e.Timestamp.Unix(), e.Timestamp.Format("-0700"), e.Message,
+	)
+}
+
+var reflogLineRegex = regexp.MustCompile(`^([0-9a-zA-Z-]+) ([0-9a-zA-Z-]+) (.*) <(.*)> (\d+) ([+-]\d{4})\t(.*)$`)
+
+// Matches revisions like HEAD@{2}, main@{yesterday} and @{1}
+var reflogRevisionRegex = regexp.MustCompile(`^(.*)@\{([^}]+)\}$`)
+
+func (Reflog) path(ref string) string {
+	return filepath.Join(GOGIT_ROOT, "logs", ref)
+}
+
+// shouldLog returns true for refs whose updates are recorded. Other refs are only logged if they already have a log
+func (Reflog) shouldLog(ref string) bool {
+	if ref == HEAD || ref == STASH_REF || strings.HasPrefix(ref, "refs/heads/") || strings.HasPrefix(ref, remoteRefDir+"/") {
+		return true
+	}
+	_, err := os.Stat(reflog.path(ref))
+	return err == nil
+}
+
+// Append records an update of ref from oldOid to newOid. Nothing is logged if the ref ends up empty
+func (Reflog) Append(ref, oldOid, newOid, message string) error {
+	if newOid == "" || !reflog.shouldLog(ref) {
+		return nil
+	}
+	if oldOid == "" {
+		oldOid = ZERO_OID
+	}
+
+	name, email := data.Identity()
+	entry := ReflogEntry{oldOid, newOid, name, email, time.Now(), message}
+
+	fp := reflog.path(ref)
+	if err := os.MkdirAll(filepath.Dir(fp), FP); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(fp, os.O_APPEND|os.O_CREATE|os.O_WRONLY, FP)
+	if err != nil {
+		return err
+	}
+	if _, err = fmt.Fprintln(f, entry); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Read returns the log of ref, newest entry first
+func (Reflog) Read(ref string) ([]ReflogEntry, error) {
+	buf, err := os.ReadFile(reflog.path(ref))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var entries []ReflogEntry
+	for _, line := range strings.Split(string(buf), "\n") {
+		if line == "" {
+			continue
+		}
+
+		match := reflogLineRegex.FindStringSubmatch(line)
+		if match == nil {
+			return nil, fmt.Errorf("malformed reflog line in %s: %q", ref, line)
+		}
+		unix, _ := strconv.ParseInt(match[5], 10, 64)
+		zone, _ := time.Parse("-0700", match[6])
+		timestamp := time.Unix(unix, 0).In(zone.Location())
+		entries = append([]ReflogEntry{{match[1], match[2], match[3], match[4], timestamp, match[7]}}, entries...)
+	}
+	return entries, nil
+}
+
+// write replaces the log of ref with entries, given newest first
+func (Reflog) write(ref string, entries []ReflogEntry) error {
+	if len(entries) == 0 {
+		if err := os.Remove(reflog.path(ref)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	var log string
+	for i := len(entries) - 1; i >= 0; i-- {
+		log += entries[i].String() + "\n"
+	}
+	return os.WriteFile(reflog.path(ref), []byte(log), FP)
+}
+
+// Refs returns the name of every ref that has a log
+func (Reflog) Refs() ([]string, error) {
+	var refs []string
+	logsDir := filepath.Join(GOGIT_ROOT, "logs")
+	err := filepath.WalkDir(logsDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return fs.SkipAll
+			}
+			return err
+		}
+		if !d.IsDir() {
+			ref, err := filepath.Rel(logsDir, path)
+			refs = append(refs, filepath.ToSlash(ref))
+			return err
+		}
+		return nil
+	})
+	return refs, err
+}
+
+// Delete removes the nth newest entry from the log of ref. With rewrite, the entry after it takes over its
+// old oid and the ref itself is moved when the newest entry is dropped, or deleted along with its last entry
+func (Reflog) Delete(ref string, n int, rewrite bool) error {
+	entries, err := reflog.Read(ref)
+	if err != nil {
+		return err
+	}
+	if n < 0 || n >= len(entries) {
+		return fmt.Errorf("%s@{%d} does not exist", ref, n)
+	}
+
+	if rewrite && n > 0 {
+		entries[n-1].OldOid = entries[n].OldOid
+	}
+	entries = append(entries[:n], entries[n+1:]...)
+	if err = reflog.write(ref, entries); err != nil {
+		return err
+	}
+
+	if !rewrite || n > 0 {
+		return nil
+	}
+	if len(entries) == 0 {
+		return data.DeleteRef(ref, false)
+	}
+	return data.writeRef(ref, &RefValue{false, entries[0].NewOid})
+}
+
+// Expire removes the entries of ref older than before and returns how many were removed
+func (Reflog) Expire(ref string, before time.Time) (int, error) {
+	entries, err := reflog.Read(ref)
+	if err != nil {
+		return 0, err
+	}
+
+	var kept []ReflogEntry
+	for _, entry := range entries {
+		if !entry.Timestamp.Before(before) {
+			kept = append(kept, entry)
+		}
+	}
+	return len(entries) - len(kept), reflog.write(ref, kept)
+}
+
+// refName returns the full name of the ref a short name like "main" refers to
+func (Reflog) refName(name string) (string, error) {
+	if name == "" || name == "@" {
+		// A bare @{...} refers to the current branch
+		branch, err := base.GetBranch()
+		if err != nil {
+			return "", err
+		}
+		if branch == "" {
+			return HEAD, nil
+		}
+		return filepath.Join("refs", "heads", branch), nil
+	}
+
+	for _, dir := range localRefDirs {
+		fp := filepath.Join(dir, name)
+		if _, err := os.Stat(reflog.path(fp)); err == nil {
+			return fp, nil
+		}
+		if ref, err := data.GetRef(fp, false); err == nil && ref.Value != "" {
+			return fp, nil
+		}
+	}
+	return "", RefNotFoundError{ref: name}
+}
+
+// ParseRevision splits a revision like "main@{2}" into the ref name and selector. Returns false if the
+// revision does not select a reflog entry
+func (Reflog) ParseRevision(rev string) (string, string, bool) {
+	match := reflogRevisionRegex.FindStringSubmatch(rev)
+	if match == nil {
+		return "", "", false
+	}
+	return match[1], match[2], true
+}
+
+// Resolve returns the oid a ref pointed to according to its log. The selector is either the
+// number of updates to go back or a date, in which case the value at that time is returned
+func (Reflog) Resolve(name, selector string) (string, error) {
+	ref, err := reflog.refName(name)
+	if err != nil {
+		return "", err
+	}
+	entries, err := reflog.Read(ref)
+	if err != nil {
+		return "", err
+	}
+	if len(entries) == 0 {
+		return "", fmt.Errorf("no reflog for %s", ref)
+	}
+
+	if n, err := strconv.Atoi(selector); err == nil {
+		if n < 0 || n >= len(entries) {
+			return "", fmt.Errorf("log for %s only has %d entries", ref, len(entries))
+		}
+		return entries[n].NewOid, nil
+	}
+
+	at, err := parseDate(selector, time.Now())
+	if err != nil {
+		return "", err
+	}
+	for _, entry := range entries {
+		if !entry.Timestamp.After(at) {
+			return entry.NewOid, nil
+		}
+	}
+
+	// The date is before the log begins, so the best we know is the value the oldest entry replaced
+	oldest := entries[len(entries)-1]
+	if oldest.OldOid == ZERO_OID {
+		return "", fmt.Errorf("log for %s only goes back to %s", ref, oldest.Timestamp.Format(time.RFC1123Z))
+	}
+	return oldest.OldOid, nil
+}
+
+// ReachableOids returns every oid recorded in any reflog
+func (Reflog) ReachableOids() ([]string, error) {
+	refs, err := reflog.Refs()
+	if err != nil {
+		return nil, err
+	}
+
+	var oids []string
+	for _, ref := range refs {
+		entries, err := reflog.Read(ref)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			for _, oid := range []string{entry.OldOid, entry.NewOid} {
+				// Objects of expired entries may already have been pruned
+				if oid != ZERO_OID && data.ObjectExists(oid) {
+					oids = append(oids, oid)
+				}
+			}
+		}
+	}
+	return oids, nil
+}
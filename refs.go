@@ -0,0 +1,337 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Suffix of the file a ref is written to before it is renamed into place
+const LOCK_SUFFIX = ".lock"
+
+type refUpdate struct {
+	name     string    // Ref as given by the caller
+	deref    bool      // Whether symbolic refs are followed before writing
+	value    *RefValue // New value, nil deletes the ref
+	oldOid   string    // Expected value. Empty skips the check, ZERO_OID requires the ref to not exist
+	reason   string
+	refName  string // Ref actually written once name is dereferenced
+	previous string
+	lock     *os.File
+}
+
+// RefTransaction groups ref updates so that either all of them are applied or none are
+type RefTransaction struct {
+	updates []*refUpdate
+}
+
+func (Data) NewRefTransaction() *RefTransaction {
+	return &RefTransaction{}
+}
+
+// Update queues setting name to ref. If oldOid is not empty the transaction fails unless name
+// currently holds that oid, with ZERO_OID meaning it must not exist yet
+func (t *RefTransaction) Update(name string, ref *RefValue, deref bool, oldOid, reason string) {
+	t.updates = append(t.updates, &refUpdate{name: name, deref: deref, value: ref, oldOid: oldOid, reason: reason})
+}
+
+// Delete queues removing name and its log, checking oldOid as Update does
+func (t *RefTransaction) Delete(name string, deref bool, oldOid string) {
+	t.updates = append(t.updates, &refUpdate{name: name, deref: deref, oldOid: oldOid})
+}
+
+func (Data) refPath(name string) string {
+	return filepath.Join(GOGIT_ROOT, name)
+}
+
+// lockRef creates the lock file of a ref. The lock is exclusive, so a second process trying to
+// update the same ref fails instead of overwriting the first one's change
+func (Data) lockRef(name string) (*os.File, error) {
+	path := data.refPath(name)
+	if err := os.MkdirAll(filepath.Dir(path), FP); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path+LOCK_SUFFIX, os.O_WRONLY|os.O_CREATE|os.O_EXCL, FP)
+	if os.IsExist(err) {
+		return nil, RefLockError{ref: name}
+	}
+	return f, err
+}
+
+func (Data) formatRef(ref *RefValue) string {
+	if ref.Symbolic {
+		return fmt.Sprintf("ref: %s", ref.Value)
+	}
+	return ref.Value
+}
+
+// Commit locks every ref in the transaction, checks their expected values and then moves the new values
+// into place. Nothing is written if any ref is locked by another process or has changed unexpectedly
+func (t *RefTransaction) Commit() error {
+	for _, u := range t.updates {
+		refName, _, err := data.getRefInternal(u.name, u.deref)
+		if err != nil {
+			return err
+		}
+		u.refName = refName
+	}
+
+	// Locks are always taken in the same order so concurrent transactions cannot deadlock
+	sorted := slices.Clone(t.updates)
+	slices.SortFunc(sorted, func(a, b *refUpdate) int {
+		return strings.Compare(a.refName, b.refName)
+	})
+
+	defer func() {
+		for _, u := range sorted {
+			if u.lock != nil {
+				u.lock.Close()
+				os.Remove(data.refPath(u.refName) + LOCK_SUFFIX)
+			}
+		}
+	}()
+
+	for i, u := range sorted {
+		if i > 0 && sorted[i-1].refName == u.refName {
+			return fmt.Errorf("multiple updates for ref \"%s\" in one transaction", u.refName)
+		}
+		lock, err := data.lockRef(u.refName)
+		if err != nil {
+			return err
+		}
+		u.lock = lock
+	}
+
+	// Deleted refs may also live in the packed refs file, which is then rewritten without them
+	packed, err := data.readPackedRefs()
+	if err != nil {
+		return err
+	}
+	var packedLock *os.File
+	for _, u := range t.updates {
+		if _, ok := packed[u.refName]; ok && u.value == nil && packedLock == nil {
+			if packedLock, err = data.lockRef(PACKED_REFS_FILE); err != nil {
+				return err
+			}
+			defer func() {
+				packedLock.Close()
+				os.Remove(data.packedRefsPath() + LOCK_SUFFIX)
+			}()
+		}
+	}
+
+	for _, u := range t.updates {
+		current, err := data.GetRef(u.name, true)
+		if err != nil {
+			return err
+		}
+		u.previous = current.Value
+
+		if actual := cmp.Or(current.Value, ZERO_OID); u.oldOid != "" && actual != u.oldOid {
+			return RefConflictError{ref: u.name, expected: u.oldOid, actual: actual}
+		}
+	}
+
+	for _, u := range t.updates {
+		if u.value == nil {
+			continue
+		}
+		if _, err := u.lock.WriteString(data.formatRef(u.value)); err != nil {
+			return err
+		}
+		if err := u.lock.Close(); err != nil {
+			return err
+		}
+	}
+
+	if packedLock != nil {
+		packed = maps.Clone(packed)
+		for _, u := range t.updates {
+			if u.value == nil {
+				delete(packed, u.refName)
+			}
+		}
+		if err := data.writePackedRefs(packedLock, packed); err != nil {
+			return err
+		}
+	}
+
+	for _, u := range t.updates {
+		path := data.refPath(u.refName)
+		if u.value == nil {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			if err := os.Remove(reflog.path(u.refName)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			continue
+		}
+
+		if err := os.Rename(path+LOCK_SUFFIX, path); err != nil {
+			return err
+		}
+		u.lock = nil
+	}
+
+	for _, u := range t.updates {
+		if u.value == nil {
+			continue
+		}
+		current, err := data.GetRef(u.name, true)
+		if err != nil {
+			return err
+		}
+		if err = reflog.Append(u.refName, u.previous, current.Value, u.reason); err != nil {
+			return err
+		}
+		// Updates made through a symbolic ref like HEAD are logged for it as well
+		if u.refName != u.name {
+			if err = reflog.Append(u.name, u.previous, current.Value, u.reason); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// File in the gogit directory holding refs folded out of their loose files
+const PACKED_REFS_FILE = "packed-refs"
+
+type packedRefsCacheEntry struct {
+	modTime time.Time
+	size    int64
+	refs    map[string]string
+}
+
+// The packed refs file is only re-parsed when it changes on disk
+var packedRefsCache = map[string]packedRefsCacheEntry{}
+
+func (Data) packedRefsPath() string {
+	return filepath.Join(GOGIT_ROOT, PACKED_REFS_FILE)
+}
+
+// readPackedRefs returns the refs in the packed refs file as a map of ref name to oid. The map is
+// shared with the cache and must be cloned before it is modified
+func (Data) readPackedRefs() (map[string]string, error) {
+	fp := data.packedRefsPath()
+	info, err := os.Stat(fp)
+	if err != nil {
+		delete(packedRefsCache, fp)
+		if os.IsNotExist(err) {
+			return map[string]string{}, nil
+		}
+		return nil, err
+	}
+
+	if cached, ok := packedRefsCache[fp]; ok && cached.modTime.Equal(info.ModTime()) && cached.size == info.Size() {
+		return cached.refs, nil
+	}
+
+	buf, err := os.ReadFile(fp)
+	if err != nil {
+		return nil, err
+	}
+
+	refs := make(map[string]string)
+	for _, line := range strings.Split(string(buf), "\n") {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		oid, name, ok := strings.Cut(line, " ")
+		if !ok {
+			return nil, fmt.Errorf("malformed line in %s: %q", PACKED_REFS_FILE, line)
+		}
+		refs[name] = oid
+	}
+	packedRefsCache[fp] = packedRefsCacheEntry{info.ModTime(), info.Size(), refs}
+	return refs, nil
+}
+
+// writePackedRefs writes refs into the held lock of the packed refs file and moves it into place
+func (Data) writePackedRefs(lock *os.File, refs map[string]string) error {
+	content := "# gogit packed-refs\n"
+	for _, name := range slices.Sorted(maps.Keys(refs)) {
+		content += fmt.Sprintf("%s %s\n", refs[name], name)
+	}
+
+	if _, err := lock.WriteString(content); err != nil {
+		return err
+	}
+	if err := lock.Close(); err != nil {
+		return err
+	}
+	delete(packedRefsCache, data.packedRefsPath())
+	return os.Rename(data.packedRefsPath()+LOCK_SUFFIX, data.packedRefsPath())
+}
+
+// PackRefs folds loose tags, or every loose ref when all is set, into the packed refs file and removes
+// the loose files. Symbolic refs are never packed. Returns the number of refs packed
+func (Data) PackRefs(all bool) (int, error) {
+	lock, err := data.lockRef(PACKED_REFS_FILE)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		lock.Close()
+		os.Remove(data.packedRefsPath() + LOCK_SUFFIX)
+	}()
+
+	packed, err := data.readPackedRefs()
+	if err != nil {
+		return 0, err
+	}
+
+	loose := make(map[string]string)
+	err = filepath.WalkDir(filepath.Join(GOGIT_ROOT, "refs"), func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || strings.HasSuffix(path, LOCK_SUFFIX) {
+			return err
+		}
+
+		name, err := filepath.Rel(GOGIT_ROOT, path)
+		if err != nil {
+			return err
+		}
+		name = filepath.ToSlash(name)
+		if !all && !strings.HasPrefix(name, "refs/tags/") {
+			return nil
+		}
+
+		ref, err := data.GetRef(name, false)
+		if err != nil || ref.Symbolic || ref.Value == "" {
+			return err
+		}
+		loose[name] = ref.Value
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	packed = maps.Clone(packed)
+	maps.Copy(packed, loose)
+	if err = data.writePackedRefs(lock, packed); err != nil {
+		return 0, err
+	}
+
+	// Loose refs updated while packing are newer than the packed value and are kept
+	for name, oid := range loose {
+		refLock, err := data.lockRef(name)
+		if err != nil {
+			continue
+		}
+		if buf, err := os.ReadFile(data.refPath(name)); err == nil && string(buf) == oid {
+			os.Remove(data.refPath(name))
+		}
+		refLock.Close()
+		os.Remove(data.refPath(name) + LOCK_SUFFIX)
+	}
+	return len(loose), nil
+}
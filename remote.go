@@ -65,16 +65,36 @@ func (Remote) Push(remotePath, refName string) error {
 		},
 	)
 
-	for _, oid := range objectsToPush.ToArray() {
-		err = data.pushRemoteObject(oid, remotePath)
-	}
-
 	if err != nil {
 		return err
 	}
 
+	for _, oid := range objectsToPush.ToArray() {
+		if err = data.pushRemoteObject(oid, remotePath); err != nil {
+			return err
+		}
+
+		// Large file contents live outside the object store and are sent alongside their pointer
+		lfsOid, isPointer, err := lfs.readPointer(oid)
+		if err != nil {
+			return err
+		}
+		if isPointer {
+			if err = lfs.pushObject(lfsOid, remotePath); err != nil {
+				return err
+			}
+		}
+	}
+
+	// The remote ref is only moved if it still holds the value the push was checked against
+	expected := ZERO_OID
+	if remoteRefValue, ok := remoteRefs[refName]; ok {
+		expected = remoteRefValue
+	}
 	return data.ChangeRootDir(remotePath, func() error {
-		return data.UpdateRef(refName, &RefValue{false, localRef.Value}, true)
+		t := data.NewRefTransaction()
+		t.Update(refName, &RefValue{false, localRef.Value}, true, expected, "update by push")
+		return t.Commit()
 	})
 }
 
@@ -98,7 +118,12 @@ func (Remote) Fetch(remotePath string) error {
 		return err
 	}
 
-	if err = os.Mkdir(remoteRefDir, FP); err != nil && !os.IsExist(err) {
+	// Large file contents are not copied, they are pulled from the remote when checked out
+	if err = lfs.SetRemote(remotePath); err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(filepath.Join(GOGIT_ROOT, remoteRefDir), FP); err != nil {
 		return err
 	}
 
@@ -107,6 +132,7 @@ func (Remote) Fetch(remotePath string) error {
 			filepath.Join(remoteRefDir, filepath.Base(refName)),
 			&RefValue{false, refValue},
 			false,
+			fmt.Sprintf("fetch %s: storing head", remotePath),
 		)
 		if err != nil {
 			return err
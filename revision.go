@@ -0,0 +1,423 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type Revision struct{}
+
+// Namespacing
+var revision Revision
+
+// Abbreviated oids shorter than this are not looked up
+const MIN_ABBREV_LENGTH = 4
+
+var hexRegex = regexp.MustCompile(`^[0-9a-fA-F]+$`)
+
+// Matches the ~n, ^n and ^{type} operators that may follow a revision
+var revisionSuffixRegex = regexp.MustCompile(`^(?:~(\d*)|\^\{([a-z]*)\}|\^(\d*))`)
+
+// indexOutsideBraces returns the index of the first character of rev in chars that is not inside @{...}
+func (Revision) indexOutsideBraces(rev, chars string) int {
+	depth := 0
+	for i, c := range rev {
+		switch {
+		case c == '{':
+			depth++
+		case c == '}' && depth > 0:
+			depth--
+		case depth == 0 && strings.ContainsRune(chars, c):
+			return i
+		}
+	}
+	return -1
+}
+
+// Resolve returns the oid of the object a revision names. Besides ref names and oids it understands
+// abbreviated oids, <rev>~n, <rev>^n, <rev>^{type}, <rev>:<path>, :<path> for the index, ref@{n},
+// ref@{date}, ref@{upstream}, @{-n} for previously checked out branches and :/<regex> to search messages
+func (Revision) Resolve(rev string) (string, error) {
+	if pattern, ok := strings.CutPrefix(rev, ":/"); ok {
+		return revision.searchMessage(pattern)
+	}
+
+	if i := revision.indexOutsideBraces(rev, ":"); i > -1 {
+		if i == 0 {
+			return revision.indexPath(strings.TrimPrefix(strings.TrimPrefix(rev, ":0:"), ":"))
+		}
+		oid, err := revision.Resolve(rev[:i])
+		if err != nil {
+			return "", err
+		}
+		return revision.treePath(rev, oid, rev[i+1:])
+	}
+
+	name, suffix := rev, ""
+	if i := revision.indexOutsideBraces(rev, "~^"); i > -1 {
+		name, suffix = rev[:i], rev[i:]
+	}
+
+	oid, err := revision.resolveName(name)
+	if err != nil {
+		return "", err
+	}
+
+	for suffix != "" {
+		match := revisionSuffixRegex.FindStringSubmatch(suffix)
+		if match == nil {
+			return "", fmt.Errorf("invalid revision \"%s\"", rev)
+		}
+		suffix = suffix[len(match[0]):]
+
+		switch {
+		case strings.HasPrefix(match[0], "~"):
+			n := 1
+			if match[1] != "" {
+				n, _ = strconv.Atoi(match[1])
+			}
+			for range n {
+				if oid, err = revision.parent(rev, oid, 1); err != nil {
+					return "", err
+				}
+			}
+		case strings.HasPrefix(match[0], "^{"):
+			if oid, err = revision.peel(rev, oid, match[2]); err != nil {
+				return "", err
+			}
+		default:
+			n := 1
+			if match[3] != "" {
+				n, _ = strconv.Atoi(match[3])
+			}
+			if n == 0 {
+				oid, err = revision.peel(rev, oid, COMMIT)
+			} else {
+				oid, err = revision.parent(rev, oid, n)
+			}
+			if err != nil {
+				return "", err
+			}
+		}
+	}
+	return oid, nil
+}
+
+// resolveName resolves a revision without any ~, ^ or :path operators
+func (Revision) resolveName(name string) (string, error) {
+	// Alias @ to HEAD
+	if name == "@" {
+		name = HEAD
+	}
+
+	if refName, selector, ok := reflog.ParseRevision(name); ok {
+		switch {
+		case selector == "upstream" || selector == "u":
+			return revision.upstream(refName)
+		case strings.HasPrefix(selector, "-") && refName == "":
+			n, err := strconv.Atoi(selector[1:])
+			if err != nil || n < 1 {
+				return "", fmt.Errorf("invalid revision \"%s\"", name)
+			}
+			branch, err := revision.PreviousBranch(n)
+			if err != nil {
+				return "", err
+			}
+			return revision.resolveName(branch)
+		default:
+			return reflog.Resolve(refName, selector)
+		}
+	}
+
+	for _, dir := range localRefDirs {
+		fp := filepath.Join(dir, name)
+		if ref, err := data.GetRef(fp, false); err == nil && ref != nil && len(ref.Value) > 0 {
+			ref, err = data.GetRef(fp, true)
+			if err != nil {
+				return "", err
+			}
+			return ref.Value, nil
+		}
+	}
+
+	if data.isValidSHA1(name) {
+		return name, nil
+	}
+	if len(name) >= MIN_ABBREV_LENGTH && hexRegex.MatchString(name) {
+		return revision.expandAbbrev(name)
+	}
+	return "", RefNotFoundError{ref: name}
+}
+
+// expandAbbrev returns the single object whose oid starts with prefix
+func (Revision) expandAbbrev(prefix string) (string, error) {
+	entries, err := os.ReadDir(filepath.Join(GOGIT_ROOT, "objects"))
+	if err != nil {
+		return "", err
+	}
+
+	var matches []string
+	prefix = strings.ToLower(prefix)
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasPrefix(entry.Name(), prefix) && data.isValidSHA1(entry.Name()) {
+			matches = append(matches, entry.Name())
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return "", RefNotFoundError{ref: prefix}
+	case 1:
+		return matches[0], nil
+	default:
+		return "", AmbiguousRevisionError{rev: prefix, candidates: matches}
+	}
+}
+
+// parent returns the nth parent of the commit oid
+func (Revision) parent(rev, oid string, n int) (string, error) {
+	oid, err := revision.peel(rev, oid, COMMIT)
+	if err != nil {
+		return "", err
+	}
+	c, err := base.GetCommit(oid)
+	if err != nil {
+		return "", err
+	}
+	if n > len(c.ParentOids) {
+		return "", fmt.Errorf("revision \"%s\" does not exist, %s has %d parents", rev, oid, len(c.ParentOids))
+	}
+	return c.ParentOids[n-1], nil
+}
+
+// peel returns the object of type _type that oid leads to. Commits peel to their tree, and an empty type
+// accepts any object
+func (Revision) peel(rev, oid, _type string) (string, error) {
+	r, t, err := data.OpenObject(oid)
+	if err != nil {
+		return "", err
+	}
+	r.Close()
+
+	switch {
+	case _type == "" || _type == t:
+		return oid, nil
+	case _type == TREE && t == COMMIT:
+		c, err := base.GetCommit(oid)
+		if err != nil {
+			return "", err
+		}
+		return c.TreeOid, nil
+	default:
+		return "", fmt.Errorf("revision \"%s\" is a %s, not a %s", rev, t, _type)
+	}
+}
+
+// treePath returns the oid of the blob or tree at path inside the tree of oid
+func (Revision) treePath(rev, oid, path string) (string, error) {
+	treeOid, err := revision.peel(rev, oid, TREE)
+	if err != nil {
+		return "", err
+	}
+
+	path = strings.Trim(filepath.ToSlash(filepath.Clean(path)), "/")
+	if path == "." || path == "" {
+		return treeOid, nil
+	}
+
+	current := treeOid
+	for _, component := range strings.Split(path, "/") {
+		entries, err := base.iterTreeEntries(current)
+		if err != nil {
+			return "", fmt.Errorf("path \"%s\" does not exist in \"%s\"", path, rev)
+		}
+
+		found := false
+		for _, entry := range entries {
+			if entry.Name == component {
+				current, found = entry.Oid, true
+				break
+			}
+		}
+		if !found {
+			return "", fmt.Errorf("path \"%s\" does not exist in \"%s\"", path, rev)
+		}
+	}
+	return current, nil
+}
+
+// indexPath returns the oid of the blob staged at path
+func (Revision) indexPath(path string) (string, error) {
+	index, err := base.GetIndexTree()
+	if err != nil {
+		return "", err
+	}
+	oid, ok := index[filepath.ToSlash(filepath.Clean(path))]
+	if !ok {
+		return "", fmt.Errorf("path \"%s\" is not in the index", path)
+	}
+	return oid, nil
+}
+
+// upstream returns the oid of the remote branch a local branch tracks, which shares its name
+func (Revision) upstream(name string) (string, error) {
+	branch := name
+	if branch == "" || branch == "@" || branch == HEAD {
+		var err error
+		if branch, err = base.GetBranch(); err != nil {
+			return "", err
+		}
+		if branch == "" {
+			return "", fmt.Errorf("HEAD does not point to a branch")
+		}
+	}
+	branch = strings.TrimPrefix(branch, "refs/heads/")
+
+	ref, err := data.GetRef(filepath.Join(remoteRefDir, branch), true)
+	if err != nil {
+		return "", err
+	}
+	if ref.Value == "" {
+		return "", fmt.Errorf("no upstream configured for branch \"%s\"", branch)
+	}
+	return ref.Value, nil
+}
+
+// PreviousBranch returns the branch, or oid when HEAD was detached, checked out n checkouts ago
+func (Revision) PreviousBranch(n int) (string, error) {
+	entries, err := reflog.Read(HEAD)
+	if err != nil {
+		return "", err
+	}
+
+	for _, entry := range entries {
+		moves, ok := strings.CutPrefix(entry.Message, "checkout: moving from ")
+		if !ok {
+			continue
+		}
+		if n--; n == 0 {
+			from, _, _ := strings.Cut(moves, " to ")
+			return from, nil
+		}
+	}
+	return "", fmt.Errorf("not enough branch switches in the reflog")
+}
+
+// searchMessage returns the newest commit reachable from any ref whose message matches pattern
+func (Revision) searchMessage(pattern string) (string, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return "", err
+	}
+
+	refIter, err := data.iterRefs("", true)
+	if err != nil {
+		return "", err
+	}
+	var tips []string
+	for _, ref := range refIter {
+		tips = append(tips, ref.Value)
+	}
+
+	var matches []string
+	commits := make(map[string]*CommitObject)
+	for oid := range base.iterCommitsAndParents(tips) {
+		c, err := base.GetCommit(oid)
+		if err != nil {
+			return "", err
+		}
+		if re.MatchString(c.Message) {
+			matches = append(matches, oid)
+			commits[oid] = c
+		}
+	}
+	if len(matches) == 0 {
+		return "", fmt.Errorf("no commit message matches \"%s\"", pattern)
+	}
+
+	return slices.MaxFunc(matches, func(a, b string) int {
+		return commits[a].Timestamp.Compare(commits[b].Timestamp)
+	}), nil
+}
+
+// ParseRange resolves revisions into the commits to include and the commits whose history is excluded.
+// A..B excludes A and includes B, A...B includes both but excludes their merge base, ^A excludes A and
+// an empty side of a range is HEAD
+func (Revision) ParseRange(revs []string) ([]string, []string, error) {
+	var include, exclude []string
+	resolve := func(revs ...string) ([]string, error) {
+		var oids []string
+		for _, rev := range revs {
+			oid, err := revision.Resolve(cmp.Or(rev, HEAD))
+			if err != nil {
+				return nil, err
+			}
+			oids = append(oids, oid)
+		}
+		return oids, nil
+	}
+
+	for _, rev := range revs {
+		if from, to, ok := strings.Cut(rev, "..."); ok {
+			oids, err := resolve(from, to)
+			if err != nil {
+				return nil, nil, err
+			}
+			include = append(include, oids...)
+			if mergeBase, err := base.getMergeBase(oids[0], oids[1]); err == nil {
+				exclude = append(exclude, mergeBase)
+			}
+		} else if from, to, ok := strings.Cut(rev, ".."); ok {
+			oids, err := resolve(from, to)
+			if err != nil {
+				return nil, nil, err
+			}
+			exclude = append(exclude, oids[0])
+			include = append(include, oids[1])
+		} else if excluded, ok := strings.CutPrefix(rev, "^"); ok {
+			oids, err := resolve(excluded)
+			if err != nil {
+				return nil, nil, err
+			}
+			exclude = append(exclude, oids...)
+		} else {
+			oids, err := resolve(rev)
+			if err != nil {
+				return nil, nil, err
+			}
+			include = append(include, oids...)
+		}
+	}
+	return include, exclude, nil
+}
+
+// ExpandNot removes every "--not" from args, flipping whether the revisions following it are excluded
+func (Revision) ExpandNot(args []string) []string {
+	var expanded []string
+	negate := false
+	for i, arg := range args {
+		// Paths are never revisions
+		if arg == "--" {
+			return append(expanded, args[i:]...)
+		}
+		if arg == "--not" {
+			negate = !negate
+			continue
+		}
+		if negate && !strings.HasPrefix(arg, "-") && !strings.Contains(arg, "..") {
+			if included, ok := strings.CutPrefix(arg, "^"); ok {
+				arg = included
+			} else {
+				arg = "^" + arg
+			}
+		}
+		expanded = append(expanded, arg)
+	}
+	return expanded
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Stash struct{}
+
+// Namespacing
+var stash Stash
+
+const STASH_REF = "refs/stash"
+
+var stashNameRegex = regexp.MustCompile(`^stash@\{(\d+)\}$`)
+
+// resolve returns the position in the stash log of a name like "stash@{1}". An empty name is the latest entry
+func (Stash) resolve(name string) (int, []ReflogEntry, error) {
+	entries, err := reflog.Read(STASH_REF)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(entries) == 0 {
+		return 0, nil, fmt.Errorf("no stash entries found")
+	}
+
+	n := 0
+	if name != "" {
+		match := stashNameRegex.FindStringSubmatch(name)
+		if match == nil {
+			if n, err = strconv.Atoi(name); err != nil {
+				return 0, nil, fmt.Errorf("%s is not a valid stash reference", name)
+			}
+		} else {
+			n, _ = strconv.Atoi(match[1])
+		}
+	}
+	if n >= len(entries) {
+		return 0, nil, fmt.Errorf("stash@{%d} does not exist", n)
+	}
+	return n, entries, nil
+}
+
+// describeHead returns the branch name and a one line summary of HEAD used in stash messages
+func (Stash) describeHead(headOid string) (string, string, error) {
+	branch, err := base.GetBranch()
+	if err != nil {
+		return "", "", err
+	}
+	if branch == "" {
+		branch = "(no branch)"
+	}
+
+	c, err := base.GetCommit(headOid)
+	if err != nil {
+		return "", "", err
+	}
+	subject, _, _ := strings.Cut(c.Message, "\n")
+	return branch, fmt.Sprintf("%s %s", headOid[:min(7, len(headOid))], subject), nil
+}
+
+// Push records the index and the tracked files of the working directory as commits under refs/stash,
+// then resets both to HEAD. The worktree commit has HEAD and the index commit as parents, followed by
+// a parentless commit of the untracked files when includeUntracked is set. With keepIndex the working
+// directory is reset to the index instead and the index is left as it is
+func (Stash) Push(message string, includeUntracked, keepIndex bool, timestamp time.Time) (string, error) {
+	headRef, err := data.GetRef(HEAD, true)
+	if err != nil {
+		return "", err
+	}
+	if headRef.Value == "" {
+		return "", fmt.Errorf("you do not have the initial commit yet")
+	}
+
+	headTree, err := base.getCommitTree(HEAD)
+	if err != nil {
+		return "", err
+	}
+	index, err := base.GetIndexTree()
+	if err != nil {
+		return "", err
+	}
+	working, err := base.GetWorkingTree()
+	if err != nil {
+		return "", err
+	}
+
+	worktree, untracked := make(Tree), make(Tree)
+	for path, oid := range working {
+		if _, ok := index[path]; ok {
+			worktree[path] = oid
+		} else if includeUntracked {
+			untracked[path] = oid
+		}
+	}
+
+	changed := len(untracked) > 0
+	for _, oids := range diff.compareTrees(headTree, index, worktree) {
+		if oids[0] != oids[1] || oids[1] != oids[2] {
+			changed = true
+			break
+		}
+	}
+	if !changed {
+		return "", fmt.Errorf("no local changes to save")
+	}
+
+	branch, summary, err := stash.describeHead(headRef.Value)
+	if err != nil {
+		return "", err
+	}
+	if message == "" {
+		message = fmt.Sprintf("WIP on %s: %s", branch, summary)
+	} else {
+		message = fmt.Sprintf("On %s: %s", branch, message)
+	}
+
+	indexTreeOid, err := base.WriteTreeFrom(index)
+	if err != nil {
+		return "", err
+	}
+	indexOid, err := base.writeCommit(CommitObject{
+		indexTreeOid, []string{headRef.Value}, base.author(), timestamp, timestamp,
+		fmt.Sprintf("index on %s: %s", branch, summary),
+	})
+	if err != nil {
+		return "", err
+	}
+	parents := []string{headRef.Value, indexOid}
+
+	if len(untracked) > 0 {
+		untrackedTreeOid, err := base.WriteTreeFrom(untracked)
+		if err != nil {
+			return "", err
+		}
+		untrackedOid, err := base.writeCommit(CommitObject{
+			untrackedTreeOid, nil, base.author(), timestamp, timestamp,
+			fmt.Sprintf("untracked files on %s: %s", branch, summary),
+		})
+		if err != nil {
+			return "", err
+		}
+		parents = append(parents, untrackedOid)
+	}
+
+	worktreeTreeOid, err := base.WriteTreeFrom(worktree)
+	if err != nil {
+		return "", err
+	}
+	oid, err := base.writeCommit(CommitObject{worktreeTreeOid, parents, base.author(), timestamp, timestamp, message})
+	if err != nil {
+		return "", err
+	}
+
+	if err = data.UpdateRef(STASH_REF, &RefValue{false, oid}, false, message); err != nil {
+		return "", err
+	}
+
+	// Local changes are now safe in the stash, so they can be discarded
+	target := headTree
+	if keepIndex {
+		target = index
+	}
+	if err = base.checkoutIndex(index, target, CHECKOUT_FORCE); err != nil {
+		return "", err
+	}
+	if !keepIndex {
+		err = data.WithIndex(func(map[string]string) (map[string]string, error) {
+			return headTree, nil
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+
+	for path := range untracked {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+		data.removeEmptyDirs(filepath.Dir(path))
+	}
+	return message, nil
+}
+
+// List returns the stash log, newest entry first
+func (Stash) List() ([]ReflogEntry, error) {
+	return reflog.Read(STASH_REF)
+}
+
+// Trees returns the tree of the commit a stash entry was made on and the tree of its stashed working directory
+func (Stash) Trees(name string) (Tree, Tree, error) {
+	n, entries, err := stash.resolve(name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c, err := base.GetCommit(entries[n].NewOid)
+	if err != nil {
+		return nil, nil, err
+	}
+	baseTree, err := base.getCommitTree(c.ParentOids[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	stashTree, err := base.GetTree(c.TreeOid, "")
+	if err != nil {
+		return nil, nil, err
+	}
+	return baseTree, stashTree, nil
+}
+
+// Apply merges the changes of a stash entry into the index and working directory. Changes to files
+// the index already tracks are left unstaged, files new in the stash are staged, and stashed untracked
+// files are written back without being staged
+func (Stash) Apply(name string) error {
+	n, entries, err := stash.resolve(name)
+	if err != nil {
+		return err
+	}
+	c, err := base.GetCommit(entries[n].NewOid)
+	if err != nil {
+		return err
+	}
+
+	baseTree, stashTree, err := stash.Trees(name)
+	if err != nil {
+		return err
+	}
+
+	untracked := Tree{}
+	if len(c.ParentOids) > 2 {
+		if untracked, err = base.getCommitTree(c.ParentOids[2]); err != nil {
+			return err
+		}
+		for path := range untracked {
+			if _, err := os.Stat(path); err == nil {
+				return fmt.Errorf("%s already exists, no checkout", path)
+			}
+		}
+	}
+
+	return data.WithIndex(func(index map[string]string) (map[string]string, error) {
+		merged, err := diff.MergeTrees(baseTree, index, stashTree)
+		if err != nil {
+			return nil, err
+		}
+		if err = base.checkoutIndex(index, merged, CHECKOUT_SAFE); err != nil {
+			return nil, err
+		}
+
+		for path, oid := range untracked {
+			if err := os.MkdirAll(filepath.Dir(path), FP); err != nil {
+				return nil, err
+			}
+			if err := base.writeWorkingFile(path, oid); err != nil {
+				return nil, err
+			}
+		}
+
+		for path, oids := range diff.compareTrees(index, merged) {
+			if oids[0] == "" {
+				index[path] = oids[1]
+			} else if oids[1] == "" {
+				delete(index, path)
+			}
+		}
+		return index, nil
+	})
+}
+
+// Drop removes an entry from the stash and returns the oid it pointed to
+func (Stash) Drop(name string) (string, error) {
+	n, entries, err := stash.resolve(name)
+	if err != nil {
+		return "", err
+	}
+	return entries[n].NewOid, reflog.Delete(STASH_REF, n, true)
+}
+
+// Pop applies a stash entry and drops it if it applied cleanly
+func (Stash) Pop(name string) (string, error) {
+	if err := stash.Apply(name); err != nil {
+		return "", err
+	}
+	return stash.Drop(name)
+}
+
+// Clear removes every stash entry
+func (Stash) Clear() error {
+	return data.DeleteRef(STASH_REF, false)
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"time"
 )
@@ -36,20 +37,46 @@ const remoteRefDir = "refs/remote"
 type CommitObject struct {
 	TreeOid    string
 	ParentOids []string
-	Timestamp  time.Time
+	Author     string // "Name <email>", empty for commits written before authors were recorded
+	AuthorTime time.Time
+	Timestamp  time.Time // Time the commit was written, which rebase and amend update
 	Message    string
 }
 
 func (c CommitObject) String() string {
 	s := fmt.Sprintf("tree %s\n", c.TreeOid)
-	s += fmt.Sprintf("time %s\n", c.Timestamp)
+	s += fmt.Sprintf("time %d %s\n", c.Timestamp.Unix(), c.Timestamp.Format("-0700"))
 	for _, parentOid := range c.ParentOids {
 		s += fmt.Sprintf("parent %s\n", parentOid)
 	}
+	if c.Author != "" && !c.AuthorTime.IsZero() {
+		s += fmt.Sprintf("author %s %d %s\n", c.Author, c.AuthorTime.Unix(), c.AuthorTime.Format("-0700"))
+	} else if c.Author != "" {
+		s += fmt.Sprintf("author %s\n", c.Author)
+	}
 	s += fmt.Sprintf("message %s", c.Message)
 	return s
 }
 
+// LogOptions select the commits printed by log and how they are formatted
+type LogOptions struct {
+	Format       string // Placeholders expanded by formatCommit, empty for the default output
+	MaxCount     int    // Zero prints every commit
+	Since        time.Time
+	Until        time.Time
+	Author       *regexp.Regexp
+	Grep         *regexp.Regexp
+	Pickaxe      string         // Only commits changing how often this string occurs
+	PickaxeRegex *regexp.Regexp // Only commits adding or removing a line matching this
+	Reverse      bool
+	FirstParent  bool
+	Graph        bool // Draw the history as lanes, children before their parents
+	Order        int  // ORDER_DEFAULT, ORDER_DATE, ORDER_AUTHOR_DATE or ORDER_TOPO
+	Merges       int  // MERGES_ANY, MERGES_ONLY or MERGES_EXCLUDED
+	Paths        []string
+	Follow       RenameOptions // Detection used to follow the one file in Paths across renames, off when empty
+}
+
 type TreeEntry struct {
 	Name string
 	Oid  string
@@ -90,3 +117,50 @@ type RefNotFoundError struct {
 func (err RefNotFoundError) Error() string {
 	return fmt.Sprintf("no ref found with name \"%s\"", err.ref)
 }
+
+type AmbiguousRevisionError struct {
+	rev        string
+	candidates []string
+}
+
+func (err AmbiguousRevisionError) Error() string {
+	return fmt.Sprintf("short oid \"%s\" is ambiguous, candidates are:\n\t%s", err.rev, strings.Join(err.candidates, "\n\t"))
+}
+
+type CheckoutConflictError struct {
+	staged    []string
+	modified  []string
+	untracked []string
+}
+
+func (err CheckoutConflictError) Error() string {
+	var msg string
+	if len(err.staged) > 0 {
+		msg += fmt.Sprintf("your staged changes to the following files would be overwritten:\n\t%s\n", strings.Join(err.staged, "\n\t"))
+	}
+	if len(err.modified) > 0 {
+		msg += fmt.Sprintf("your local changes to the following files would be overwritten:\n\t%s\n", strings.Join(err.modified, "\n\t"))
+	}
+	if len(err.untracked) > 0 {
+		msg += fmt.Sprintf("the following untracked files would be overwritten:\n\t%s\n", strings.Join(err.untracked, "\n\t"))
+	}
+	return msg + "commit or stash your changes, or use --force to discard them"
+}
+
+type RefLockError struct {
+	ref string
+}
+
+func (err RefLockError) Error() string {
+	return fmt.Sprintf("unable to lock ref \"%s\": %s%s exists, another gogit process may be running", err.ref, err.ref, LOCK_SUFFIX)
+}
+
+type RefConflictError struct {
+	ref      string
+	expected string
+	actual   string
+}
+
+func (err RefConflictError) Error() string {
+	return fmt.Sprintf("ref \"%s\" was updated concurrently, expected %s but found %s", err.ref, err.expected, err.actual)
+}